@@ -0,0 +1,535 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ErrorCodeCircuitOpen  ErrorCode = "circuit_open"
+	ErrorCodeBulkheadFull ErrorCode = "bulkhead_full"
+)
+
+// errCircuitCallPanicked records a call that panicked, it is always a failure.
+var errCircuitCallPanicked = errors.New("circuit breaker call panicked")
+
+var (
+	ErrCircuitOpen  = NewError("circuit breaker is open").WithCode(ErrorCodeCircuitOpen).WithCategory(ErrorCategoryUnavailable)
+	ErrBulkheadFull = NewError("bulkhead is full").WithCode(ErrorCodeBulkheadFull).WithCategory(ErrorCategoryUnavailable)
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type CircuitBreakerOptions struct {
+	Name string
+	// Window is the rolling window failures and latencies are counted in, default 10s.
+	Window time.Duration
+	// Buckets splits Window, default 10.
+	Buckets int
+	// MinRequests in the window before the breaker may trip, default 20.
+	MinRequests int
+	// FailureRatio trips the breaker when reached, default 0.5.
+	FailureRatio float64
+	// SlowCallDuration makes calls taking at least this long count as slow, 0 disables latency tracking.
+	SlowCallDuration time.Duration
+	// SlowCallRatio trips the breaker when reached, default 1.
+	SlowCallRatio float64
+	// OpenTimeout is how long the breaker stays open before letting trial calls through, default 30s.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls trial calls must all succeed to close the breaker, default 1.
+	HalfOpenMaxCalls int
+	// IsFailure classifies results, by default any error but context.Canceled is a failure.
+	IsFailure     func(err error) bool
+	OnStateChange func(name string, from, to CircuitState)
+}
+
+type CircuitBreakerStats struct {
+	State CircuitState
+	// Requests, Failures and SlowCalls are counted in the rolling window.
+	Requests  int
+	Failures  int
+	SlowCalls int
+	// Rejected counts calls short-circuited since the breaker was created.
+	Rejected int64
+	// LastChange is when the breaker last changed its state.
+	LastChange time.Time
+}
+
+type circuitBucket struct {
+	start     time.Time
+	requests  int
+	failures  int
+	slowCalls int
+}
+
+// CircuitBreaker stops calling a failing dependency: it opens when the failure or slow call
+// ratio in its rolling window is reached, rejects calls with ErrCircuitOpen for OpenTimeout,
+// then lets HalfOpenMaxCalls trial calls through to decide whether to close again.
+type CircuitBreaker struct {
+	opts CircuitBreakerOptions
+
+	lock       sync.Mutex
+	state      CircuitState
+	generation uint64
+	lastChange time.Time
+	buckets    []circuitBucket
+	rejected   int64
+	// trial calls in flight and succeeded in half-open state
+	halfOpenCalls     int
+	halfOpenSuccesses int
+	// state changes to notify once unlocked
+	changes []CircuitState
+}
+
+func NewCircuitBreaker(opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.Buckets <= 0 {
+		opts.Buckets = 10
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.SlowCallRatio <= 0 {
+		opts.SlowCallRatio = 1
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenMaxCalls <= 0 {
+		opts.HalfOpenMaxCalls = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+	return &CircuitBreaker{
+		opts:       opts,
+		lastChange: time.Now(),
+		buckets:    make([]circuitBucket, opts.Buckets),
+	}
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.opts.Name
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	b.lock.Lock()
+	defer b.unlock()
+	b.refreshState(time.Now())
+	return b.state
+}
+
+func (b *CircuitBreaker) Stats() CircuitBreakerStats {
+	b.lock.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.refreshState(now)
+	stats := CircuitBreakerStats{State: b.state, Rejected: b.rejected, LastChange: b.lastChange}
+	stats.Requests, stats.Failures, stats.SlowCalls = b.windowCounts(now)
+	return stats
+}
+
+// Execute calls fn if the breaker allows it and records its result,
+// otherwise it returns an error matching ErrCircuitOpen without calling fn.
+// A panic of fn is recorded as a failure and keeps propagating.
+func (b *CircuitBreaker) Execute(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			done(errCircuitCallPanicked)
+		}
+	}()
+	err = fn()
+	completed = true
+	done(err)
+	return err
+}
+
+// Allow reports whether a call may proceed, the caller must then pass the call's result to done.
+func (b *CircuitBreaker) Allow() (done func(err error), err error) {
+	b.lock.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.refreshState(now)
+	switch b.state {
+	case CircuitOpen:
+		b.rejected++
+		return nil, ErrCircuitOpen.WithField("breaker", b.opts.Name)
+	case CircuitHalfOpen:
+		if b.halfOpenCalls >= b.opts.HalfOpenMaxCalls {
+			b.rejected++
+			return nil, ErrCircuitOpen.WithField("breaker", b.opts.Name)
+		}
+		b.halfOpenCalls++
+	}
+	generation := b.generation
+	return func(err error) {
+		b.record(generation, now, err)
+	}, nil
+}
+
+func (b *CircuitBreaker) record(generation uint64, start time.Time, err error) {
+	b.lock.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.refreshState(now)
+	if generation != b.generation {
+		// the call started in a previous state, its result is stale
+		return
+	}
+	failed := err == errCircuitCallPanicked || b.opts.IsFailure(err)
+	slow := b.opts.SlowCallDuration > 0 && now.Sub(start) >= b.opts.SlowCallDuration
+
+	switch b.state {
+	case CircuitHalfOpen:
+		if failed || slow {
+			b.setState(CircuitOpen, now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.opts.HalfOpenMaxCalls {
+			b.setState(CircuitClosed, now)
+		}
+	case CircuitClosed:
+		bucket := b.currentBucket(now)
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slowCalls++
+		}
+		requests, failures, slowCalls := b.windowCounts(now)
+		if requests < b.opts.MinRequests {
+			return
+		}
+		if float64(failures)/float64(requests) >= b.opts.FailureRatio ||
+			(b.opts.SlowCallDuration > 0 && float64(slowCalls)/float64(requests) >= b.opts.SlowCallRatio) {
+			b.setState(CircuitOpen, now)
+		}
+	}
+}
+
+func (b *CircuitBreaker) refreshState(now time.Time) {
+	if b.state == CircuitOpen && now.Sub(b.lastChange) >= b.opts.OpenTimeout {
+		b.setState(CircuitHalfOpen, now)
+	}
+}
+
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.lastChange = now
+	b.halfOpenCalls, b.halfOpenSuccesses = 0, 0
+	for i := range b.buckets {
+		b.buckets[i] = circuitBucket{}
+	}
+	b.changes = append(b.changes, from, state)
+}
+
+// unlock releases the lock, then calls OnStateChange for the changes made while locked.
+func (b *CircuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.lock.Unlock()
+	if b.opts.OnStateChange == nil {
+		return
+	}
+	for i := 0; i+1 < len(changes); i += 2 {
+		b.opts.OnStateChange(b.opts.Name, changes[i], changes[i+1])
+	}
+}
+
+func (b *CircuitBreaker) bucketDuration() time.Duration {
+	return b.opts.Window / time.Duration(len(b.buckets))
+}
+
+func (b *CircuitBreaker) currentBucket(now time.Time) *circuitBucket {
+	size := b.bucketDuration()
+	start := now.Truncate(size)
+	bucket := &b.buckets[int(start.UnixNano()/int64(size))%len(b.buckets)]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+func (b *CircuitBreaker) windowCounts(now time.Time) (requests, failures, slowCalls int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.opts.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+			slowCalls += bucket.slowCalls
+		}
+	}
+	return
+}
+
+type BulkheadOptions struct {
+	Name string
+	// MaxConcurrent calls, default 10.
+	MaxConcurrent int
+	// MaxWait is how long a call may wait for a free slot, 0 rejects immediately when full.
+	MaxWait time.Duration
+	// OnStateChange is called when the bulkhead becomes full or has a free slot again.
+	OnStateChange func(name string, full bool)
+}
+
+type BulkheadStats struct {
+	Active   int
+	Waiting  int
+	Accepted int64
+	Rejected int64
+}
+
+// Bulkhead limits the number of concurrent calls to a dependency.
+type Bulkhead struct {
+	opts  BulkheadOptions
+	slots chan struct{}
+
+	lock     sync.Mutex
+	waiting  int
+	accepted int64
+	rejected int64
+	full     bool
+}
+
+func NewBulkhead(opts BulkheadOptions) *Bulkhead {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 10
+	}
+	return &Bulkhead{opts: opts, slots: make(chan struct{}, opts.MaxConcurrent)}
+}
+
+func (b *Bulkhead) Name() string {
+	return b.opts.Name
+}
+
+// Acquire takes a slot, waiting up to MaxWait or until ctx is done.
+// The returned release must be called once the call is finished.
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.accept(), nil
+	default:
+	}
+	if b.opts.MaxWait <= 0 {
+		return nil, b.reject()
+	}
+	b.lock.Lock()
+	b.waiting++
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		b.waiting--
+		b.lock.Unlock()
+	}()
+	timer := time.NewTimer(b.opts.MaxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.accept(), nil
+	case <-timer.C:
+		return nil, b.reject()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Execute calls fn within a slot, or returns an error matching ErrBulkheadFull.
+func (b *Bulkhead) Execute(ctx context.Context, fn func() error) error {
+	release, err := b.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+func (b *Bulkhead) Stats() BulkheadStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return BulkheadStats{Active: len(b.slots), Waiting: b.waiting, Accepted: b.accepted, Rejected: b.rejected}
+}
+
+func (b *Bulkhead) accept() func() {
+	b.lock.Lock()
+	b.accepted++
+	b.unlockAndNotify()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-b.slots
+			b.lock.Lock()
+			b.unlockAndNotify()
+		})
+	}
+}
+
+func (b *Bulkhead) reject() error {
+	b.lock.Lock()
+	b.rejected++
+	b.lock.Unlock()
+	return ErrBulkheadFull.WithField("bulkhead", b.opts.Name)
+}
+
+// unlockAndNotify updates whether the bulkhead is full, releases the lock and calls OnStateChange on change.
+func (b *Bulkhead) unlockAndNotify() {
+	full := len(b.slots) >= cap(b.slots)
+	changed := full != b.full
+	b.full = full
+	b.lock.Unlock()
+	if changed && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.opts.Name, full)
+	}
+}
+
+// CircuitBreakerTransport guards an http.RoundTripper with one CircuitBreaker per request host.
+// A request to a host whose breaker is open fails with ErrCircuitOpen without being sent.
+type CircuitBreakerTransport struct {
+	next     http.RoundTripper
+	opts     CircuitBreakerOptions
+	breakers sync.Map
+	// IsFailure classifies responses, by default transport errors and 5xx responses are failures.
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+// NewCircuitBreakerTransport returns a transport sending requests through next,
+// or http.DefaultTransport if next is nil. opts.Name is replaced by the request host.
+func NewCircuitBreakerTransport(next http.RoundTripper, opts CircuitBreakerOptions) *CircuitBreakerTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &CircuitBreakerTransport{next: next, opts: opts}
+}
+
+// Breaker returns the breaker of host, creating it if needed.
+func (t *CircuitBreakerTransport) Breaker(host string) *CircuitBreaker {
+	if v, ok := t.breakers.Load(host); ok {
+		return v.(*CircuitBreaker)
+	}
+	opts := t.opts
+	opts.Name = host
+	v, _ := t.breakers.LoadOrStore(host, NewCircuitBreaker(opts))
+	return v.(*CircuitBreaker)
+}
+
+func (t *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.Breaker(req.URL.Host).Allow()
+	if err != nil {
+		return nil, err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			done(errCircuitCallPanicked)
+		}
+	}()
+	resp, err := t.next.RoundTrip(req)
+	completed = true
+	isFailure := t.IsFailure
+	if isFailure == nil {
+		isFailure = isFailedHTTPResponse
+	}
+	switch {
+	case !isFailure(resp, err):
+		done(nil)
+	case err != nil:
+		done(err)
+	default:
+		done(errors.New(resp.Status))
+	}
+	return resp, err
+}
+
+func isFailedHTTPResponse(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// BulkheadTransport limits concurrent requests per host of an http.RoundTripper.
+type BulkheadTransport struct {
+	next      http.RoundTripper
+	opts      BulkheadOptions
+	bulkheads sync.Map
+}
+
+// NewBulkheadTransport returns a transport sending requests through next,
+// or http.DefaultTransport if next is nil. opts.Name is replaced by the request host.
+func NewBulkheadTransport(next http.RoundTripper, opts BulkheadOptions) *BulkheadTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &BulkheadTransport{next: next, opts: opts}
+}
+
+// Bulkhead returns the bulkhead of host, creating it if needed.
+func (t *BulkheadTransport) Bulkhead(host string) *Bulkhead {
+	if v, ok := t.bulkheads.Load(host); ok {
+		return v.(*Bulkhead)
+	}
+	opts := t.opts
+	opts.Name = host
+	v, _ := t.bulkheads.LoadOrStore(host, NewBulkhead(opts))
+	return v.(*Bulkhead)
+}
+
+// RoundTrip holds the host's slot until the response body is closed.
+func (t *BulkheadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.Bulkhead(req.URL.Host).Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releasingReadCloser{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
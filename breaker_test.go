@@ -0,0 +1,139 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []string
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{
+		Name:         "db",
+		MinRequests:  4,
+		FailureRatio: 0.5,
+		OpenTimeout:  20 * time.Millisecond,
+		OnStateChange: func(name string, from, to CircuitState) {
+			changes = append(changes, name+":"+from.String()+"->"+to.String())
+		},
+	})
+	assert.Nil(t, breaker.Execute(func() error { return nil }))
+	assert.Nil(t, breaker.Execute(func() error { return nil }))
+	assert.Equal(t, io.EOF, breaker.Execute(func() error { return io.EOF }))
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, io.EOF, breaker.Execute(func() error { return io.EOF }))
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	called := false
+	err := breaker.Execute(func() error {
+		called = true
+		return nil
+	})
+	assert.False(t, called)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int64(1), breaker.Stats().Rejected)
+
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	done, err := breaker.Allow()
+	assert.Nil(t, err)
+	_, err = breaker.Allow()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	done(io.EOF)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	time.Sleep(25 * time.Millisecond)
+	assert.Nil(t, breaker.Execute(func() error { return nil }))
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, []string{
+		"db:closed->open", "db:open->half-open", "db:half-open->open",
+		"db:open->half-open", "db:half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreakerPanic(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{
+		MinRequests: 1,
+		OpenTimeout: 10 * time.Millisecond,
+		IsFailure:   func(err error) bool { return err != nil },
+	})
+	assert.Equal(t, io.EOF, breaker.Execute(func() error { return io.EOF }))
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+
+	// the trial call is released and failed, the panic isn't swallowed
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = breaker.Execute(func() error { panic("boom") })
+	})
+	assert.Equal(t, CircuitOpen, breaker.State())
+	time.Sleep(15 * time.Millisecond)
+	assert.Nil(t, breaker.Execute(func() error { return nil }))
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{
+		MinRequests:      2,
+		SlowCallDuration: 5 * time.Millisecond,
+		SlowCallRatio:    0.5,
+	})
+	_ = breaker.Execute(func() error { return nil })
+	_ = breaker.Execute(func() error {
+		time.Sleep(6 * time.Millisecond)
+		return nil
+	})
+	stats := breaker.Stats()
+	assert.Equal(t, CircuitOpen, stats.State)
+}
+
+func TestBulkhead(t *testing.T) {
+	var fullChanges []bool
+	bulkhead := NewBulkhead(BulkheadOptions{
+		MaxConcurrent: 1,
+		MaxWait:       10 * time.Millisecond,
+		OnStateChange: func(name string, full bool) {
+			fullChanges = append(fullChanges, full)
+		},
+	})
+	release, err := bulkhead.Acquire(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, bulkhead.Stats().Active)
+	_, err = bulkhead.Acquire(context.Background())
+	assert.True(t, errors.Is(err, ErrBulkheadFull))
+	release()
+	release()
+	assert.Nil(t, bulkhead.Execute(context.Background(), func() error { return nil }))
+	assert.Equal(t, BulkheadStats{Accepted: 2, Rejected: 1}, bulkhead.Stats())
+	assert.Equal(t, []bool{true, false, true, false}, fullChanges)
+}
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	transport := NewCircuitBreakerTransport(nil, CircuitBreakerOptions{MinRequests: 2})
+	client := &http.Client{Transport: NewBulkheadTransport(transport, BulkheadOptions{MaxConcurrent: 2})}
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(server.URL)
+		if i < 2 {
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+			_ = resp.Body.Close()
+		} else {
+			assert.True(t, errors.Is(err, ErrCircuitOpen))
+		}
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	assert.Equal(t, CircuitOpen, transport.Breaker(server.Listener.Addr().String()).State())
+}
@@ -0,0 +1,161 @@
+package utility
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	pooledBufferMinSize = 4096
+	// pooledBufferSizeHintWeight is the inverse weight of a capacity in the size hint average
+	pooledBufferSizeHintWeight = 8
+)
+
+// DefaultBytesPool backs the buffers of GetBuffer, its Stats cover the pooled helpers.
+var DefaultBytesPool = &BytesPool{}
+
+var (
+	bufferPool sync.Pool
+	// pooledBufferSizeHint is a moving average of the lengths of the buffers put back
+	pooledBufferSizeHint int64
+	gzipReaderPool       sync.Pool
+	bufioReaderPool      sync.Pool
+	bufioWriterPool      sync.Pool
+
+	DefaultGZipWriterPool = &GZipWriterPool{level: gzip.DefaultCompression}
+)
+
+// GetBuffer returns an empty bytes.Buffer backed by DefaultBytesPool, give it back with PutBuffer.
+// Its capacity follows the average length of the buffers put back, so that steady workloads don't grow
+// buffers while a single large one doesn't inflate the following ones.
+func GetBuffer() *bytes.Buffer {
+	buf, _ := bufferPool.Get().(*bytes.Buffer)
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	size := int(atomic.LoadInt64(&pooledBufferSizeHint))
+	if size < pooledBufferMinSize {
+		size = pooledBufferMinSize
+	}
+	*buf = *bytes.NewBuffer(DefaultBytesPool.Get(size))
+	return buf
+}
+
+// PutBuffer returns buf and its memory to the pool, neither buf nor a slice from buf.Bytes() may be used afterwards.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf == nil {
+		return
+	}
+	// the length, as capacities follow the hint itself
+	if size := buf.Len(); size <= DefaultBytesPool.maxPooledSize() {
+		// racy updates only lose a sample of the average
+		hint := atomic.LoadInt64(&pooledBufferSizeHint)
+		atomic.StoreInt64(&pooledBufferSizeHint, hint+(int64(size)-hint)/pooledBufferSizeHintWeight)
+	}
+	buf.Reset()
+	DefaultBytesPool.Put(buf.Bytes())
+	*buf = bytes.Buffer{}
+	bufferPool.Put(buf)
+}
+
+// GZipWriterPool pools gzip.Writer of one compression level.
+type GZipWriterPool struct {
+	level int
+	pool  sync.Pool
+}
+
+func NewGZipWriterPool(level int) (*GZipWriterPool, error) {
+	// validate level, the writer is kept as the first pooled one
+	zw, err := gzip.NewWriterLevel(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	p := &GZipWriterPool{level: level}
+	p.pool.Put(zw)
+	return p, nil
+}
+
+func (p *GZipWriterPool) Level() int {
+	return p.level
+}
+
+// Get returns a gzip.Writer writing to w, give it back with Put once closed.
+func (p *GZipWriterPool) Get(w io.Writer) *gzip.Writer {
+	if zw, ok := p.pool.Get().(*gzip.Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+	zw, _ := gzip.NewWriterLevel(w, p.level)
+	return zw
+}
+
+func (p *GZipWriterPool) Put(zw *gzip.Writer) {
+	if zw == nil {
+		return
+	}
+	zw.Reset(nil)
+	p.pool.Put(zw)
+}
+
+// GetGZipReader returns a gzip.Reader reading from r, give it back with PutGZipReader.
+func GetGZipReader(r io.Reader) (*gzip.Reader, error) {
+	if zr, ok := gzipReaderPool.Get().(*gzip.Reader); ok {
+		if err := zr.Reset(r); err != nil {
+			gzipReaderPool.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+	return gzip.NewReader(r)
+}
+
+func PutGZipReader(zr *gzip.Reader) {
+	if zr != nil {
+		gzipReaderPool.Put(zr)
+	}
+}
+
+// GetBufioReader returns a bufio.Reader of the default size reading from r, give it back with PutBufioReader.
+func GetBufioReader(r io.Reader) *bufio.Reader {
+	if br, ok := bufioReaderPool.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+func PutBufioReader(br *bufio.Reader) {
+	if br == nil {
+		return
+	}
+	br.Reset(nil)
+	bufioReaderPool.Put(br)
+}
+
+// GetBufioWriter returns a bufio.Writer of the default size writing to w, give it back with PutBufioWriter once flushed.
+func GetBufioWriter(w io.Writer) *bufio.Writer {
+	if bw, ok := bufioWriterPool.Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriter(w)
+}
+
+func PutBufioWriter(bw *bufio.Writer) {
+	if bw == nil {
+		return
+	}
+	bw.Reset(nil)
+	bufioWriterPool.Put(bw)
+}
+
+// copyBufferBytes returns a copy of the content of buf, so that buf can go back to its pool.
+func copyBufferBytes(buf *bytes.Buffer) []byte {
+	bs := make([]byte, buf.Len())
+	copy(bs, buf.Bytes())
+	return bs
+}
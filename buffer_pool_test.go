@@ -0,0 +1,155 @@
+package utility
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var benchmarkPayload = []byte(strings.Repeat("utility buffer pool benchmark payload ", 2000))
+
+func TestPooledHelpers(t *testing.T) {
+	compressed, err := GZipCompressPooled(benchmarkPayload)
+	assert.Nil(t, err)
+	plain, err := GZipDecompress(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, benchmarkPayload, plain)
+
+	compressed, err = GZipCompress(benchmarkPayload)
+	assert.Nil(t, err)
+	plain, err = GZipDecompressPooled(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, benchmarkPayload, plain)
+
+	plain, err = ReadDecodedBodyWithHeaderPooled(compressed, "gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, benchmarkPayload, plain)
+
+	bs, release, err := ReadAllFromReadCloserPooled(io.NopCloser(bytes.NewReader(benchmarkPayload)))
+	assert.Nil(t, err)
+	assert.Equal(t, benchmarkPayload, bs)
+	release()
+
+	bs, release, err = ReadBytesPooled(iotest.ErrReader(io.ErrUnexpectedEOF))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, bs)
+	release()
+}
+
+func TestBufferPoolSizeHint(t *testing.T) {
+	defer atomic.StoreInt64(&pooledBufferSizeHint, atomic.LoadInt64(&pooledBufferSizeHint))
+	atomic.StoreInt64(&pooledBufferSizeHint, 0)
+	large := GetBuffer()
+	large.Write(make([]byte, 512<<10))
+	PutBuffer(large)
+	for i := 0; i < 50; i++ {
+		PutBuffer(GetBuffer())
+	}
+	// one large buffer doesn't size the following ones
+	assert.True(t, GetBuffer().Cap() < 64<<10)
+}
+
+func TestBufferPool(t *testing.T) {
+	buf := GetBuffer()
+	assert.Equal(t, 0, buf.Len())
+	assert.True(t, buf.Cap() >= pooledBufferMinSize)
+	buf.WriteString("hello")
+	PutBuffer(buf)
+	assert.Equal(t, 0, GetBuffer().Len())
+
+	pool, err := NewGZipWriterPool(gzip.BestSpeed)
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	zw := pool.Get(&out)
+	_, _ = zw.Write([]byte("hello"))
+	assert.Nil(t, zw.Close())
+	pool.Put(zw)
+	zr, err := GetGZipReader(&out)
+	assert.Nil(t, err)
+	plain, err := io.ReadAll(zr)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+	PutGZipReader(zr)
+
+	_, err = NewGZipWriterPool(42)
+	assert.NotNil(t, err)
+
+	out.Reset()
+	bw := GetBufioWriter(&out)
+	_, _ = bw.WriteString("line\n")
+	assert.Nil(t, bw.Flush())
+	PutBufioWriter(bw)
+	br := GetBufioReader(&out)
+	line, err := br.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "line\n", line)
+	PutBufioReader(br)
+}
+
+func BenchmarkReadBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = ReadBytes(bytes.NewReader(benchmarkPayload))
+	}
+}
+
+func BenchmarkReadBytesPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, release, _ := ReadBytesPooled(bytes.NewReader(benchmarkPayload))
+		release()
+	}
+}
+
+func BenchmarkReadAllFromReadCloser(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = ReadAllFromReadCloser(io.NopCloser(bytes.NewReader(benchmarkPayload)))
+	}
+}
+
+func BenchmarkReadAllFromReadCloserPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, release, _ := ReadAllFromReadCloserPooled(io.NopCloser(bytes.NewReader(benchmarkPayload)))
+		release()
+	}
+}
+
+func BenchmarkGZipCompress(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = GZipCompress(benchmarkPayload)
+	}
+}
+
+func BenchmarkGZipCompressPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = GZipCompressPooled(benchmarkPayload)
+	}
+}
+
+func BenchmarkReadDecodedBodyWithHeader(b *testing.B) {
+	compressed, _ := GZipCompress(benchmarkPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ReadDecodedBodyWithHeader(compressed, "gzip")
+	}
+}
+
+func BenchmarkReadDecodedBodyWithHeaderPooled(b *testing.B) {
+	compressed, _ := GZipCompress(benchmarkPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ReadDecodedBodyWithHeaderPooled(compressed, "gzip")
+	}
+}
@@ -0,0 +1,422 @@
+package utility
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ErrorCodeCassetteNoMatch ErrorCode = "cassette_no_match"
+
+	// CassetteRedacted replaces the values of redacted headers and query parameters.
+	CassetteRedacted = "REDACTED"
+)
+
+var ErrCassetteNoMatch = NewError("no recorded interaction matches the request").
+	WithCode(ErrorCodeCassetteNoMatch).WithCategory(ErrorCategoryNotFound)
+
+// DefaultRedactedHeaders are redacted from cassettes unless CassetteOptions.RedactHeaders is set.
+var DefaultRedactedHeaders = []string{
+	HTTPHeaderAuthorization, "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token",
+}
+
+type CassetteMode int
+
+const (
+	// CassetteReplayOrRecord replays matching interactions and records the others.
+	CassetteReplayOrRecord CassetteMode = iota
+	// CassetteReplay only replays, requests without a match fail with ErrCassetteNoMatch.
+	CassetteReplay
+	// CassetteRecord sends every request and records it.
+	CassetteRecord
+)
+
+type CassetteRequest struct {
+	Method string      `json:"method" yaml:"method"`
+	URL    string      `json:"url" yaml:"url"`
+	Header http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body   string      `json:"body,omitempty" yaml:"body,omitempty"`
+	// BodyBase64 holds bodies that are not valid UTF-8.
+	BodyBase64 string `json:"body_base64,omitempty" yaml:"body_base64,omitempty"`
+}
+
+// BodyBytes returns the body, whichever form it is stored in.
+func (r *CassetteRequest) BodyBytes() []byte {
+	return cassetteBodyBytes(r.Body, r.BodyBase64)
+}
+
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code" yaml:"status_code"`
+	Header     http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string      `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty" yaml:"body_base64,omitempty"`
+}
+
+func (r *CassetteResponse) BodyBytes() []byte {
+	return cassetteBodyBytes(r.Body, r.BodyBase64)
+}
+
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request" yaml:"request"`
+	Response CassetteResponse `json:"response" yaml:"response"`
+}
+
+// Cassette is a list of recorded interactions, stored as YAML, or JSON if its path ends with ".json".
+type Cassette struct {
+	Interactions []*CassetteInteraction `json:"interactions" yaml:"interactions"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	bs, err := ReadFileFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if isJSONCassette(path) {
+		err = json.Unmarshal(bs, cassette)
+	} else {
+		err = yaml.Unmarshal(bs, cassette)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	var bs []byte
+	var err error
+	if isJSONCassette(path) {
+		bs, err = json.MarshalIndent(c, "", "  ")
+	} else {
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(c); err == nil {
+			err = encoder.Close()
+		}
+		bs = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	_, err = WriteBytesToFileWithPermission(bs, path, 0644)
+	return err
+}
+
+func isJSONCassette(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// CassetteMatcher reports whether an incoming request, in its recorded and redacted form, matches a recorded one.
+type CassetteMatcher func(req, recorded *CassetteRequest) bool
+
+// MatchCassetteMethodAndURL is the default matcher.
+func MatchCassetteMethodAndURL(req, recorded *CassetteRequest) bool {
+	return req.Method == recorded.Method && req.URL == recorded.URL
+}
+
+func MatchCassetteBody(req, recorded *CassetteRequest) bool {
+	return bytes.Equal(req.BodyBytes(), recorded.BodyBytes())
+}
+
+// MatchCassetteMethodAndPath matches method and URL ignoring the query.
+func MatchCassetteMethodAndPath(req, recorded *CassetteRequest) bool {
+	return req.Method == recorded.Method && cassetteURLPath(req.URL) == cassetteURLPath(recorded.URL)
+}
+
+func cassetteURLPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// MatchCassetteHeaders matches the values of the headers given.
+func MatchCassetteHeaders(headers ...string) CassetteMatcher {
+	return func(req, recorded *CassetteRequest) bool {
+		for _, header := range headers {
+			if strings.Join(req.Header.Values(header), ",") != strings.Join(recorded.Header.Values(header), ",") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchCassetteAll matches if all matchers match.
+func MatchCassetteAll(matchers ...CassetteMatcher) CassetteMatcher {
+	return func(req, recorded *CassetteRequest) bool {
+		for _, matcher := range matchers {
+			if !matcher(req, recorded) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type CassetteOptions struct {
+	// Path of the cassette file, loaded if it exists.
+	Path string
+	Mode CassetteMode
+	// Matcher defaults to MatchCassetteMethodAndURL.
+	Matcher CassetteMatcher
+	// RedactHeaders are stored as CassetteRedacted, default DefaultRedactedHeaders.
+	RedactHeaders []string
+	// RedactQueryParams are stored as CassetteRedacted, e.g. "api_key".
+	RedactQueryParams []string
+	// ReplayRepeatedly lets an interaction answer more than one request, else each one is replayed once.
+	ReplayRepeatedly bool
+	// Transport sends the requests to record, default http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// CassetteRecorder is an http.RoundTripper that records interactions to a cassette and replays them,
+// so that tests run offline. Recorded bodies are decoded from their Content-Encoding.
+type CassetteRecorder struct {
+	opts     CassetteOptions
+	lock     sync.Mutex
+	cassette *Cassette
+	replayed []bool
+	changed  bool
+}
+
+func NewCassetteRecorder(opts CassetteOptions) (*CassetteRecorder, error) {
+	if opts.Matcher == nil {
+		opts.Matcher = MatchCassetteMethodAndURL
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactedHeaders
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	r := &CassetteRecorder{opts: opts, cassette: &Cassette{}}
+	if opts.Mode != CassetteRecord {
+		cassette, err := LoadCassette(opts.Path)
+		if err == nil {
+			r.cassette = cassette
+		} else if !os.IsNotExist(err) || opts.Mode == CassetteReplay {
+			return nil, err
+		}
+	}
+	r.replayed = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Cassette returns the interactions recorded and loaded so far.
+func (r *CassetteRecorder) Cassette() *Cassette {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return &Cassette{Interactions: append([]*CassetteInteraction(nil), r.cassette.Interactions...)}
+}
+
+// Save writes the cassette to its path if interactions were recorded.
+func (r *CassetteRecorder) Save() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.changed {
+		return nil
+	}
+	if err := r.cassette.Save(r.opts.Path); err != nil {
+		return err
+	}
+	r.changed = false
+	return nil
+}
+
+func (r *CassetteRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recordedReq, err := r.recordRequest(req, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.Mode != CassetteRecord {
+		if interaction := r.match(recordedReq); interaction != nil {
+			return replayCassetteResponse(req, &interaction.Response), nil
+		}
+		if r.opts.Mode == CassetteReplay {
+			return nil, ErrCassetteNoMatch.WithField("method", recordedReq.Method).WithField("url", recordedReq.URL)
+		}
+	}
+
+	resp, err := r.opts.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rawBody, err := ReadAllFromReadCloser(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(rawBody))
+	body := rawBody
+	// responses without a body keep their Content-Encoding, and Content-Length for HEAD
+	if len(rawBody) > 0 && req.Method != http.MethodHead &&
+		resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		body, err = ReadDecodedBodyWithHeader(rawBody, strings.Join(resp.Header.Values(HTTPHeaderContentEncoding), ","))
+		if err != nil {
+			return nil, err
+		}
+	}
+	header := r.redactHeader(resp.Header)
+	if !bytes.Equal(body, rawBody) {
+		header.Del(HTTPHeaderContentEncoding)
+		if header.Get(HTTPHeaderContentLength) != "" {
+			header.Set(HTTPHeaderContentLength, strconv.Itoa(len(body)))
+		}
+	}
+	interaction := &CassetteInteraction{
+		Request:  *recordedReq,
+		Response: CassetteResponse{StatusCode: resp.StatusCode, Header: header},
+	}
+	interaction.Response.Body, interaction.Response.BodyBase64 = cassetteBody(body)
+
+	r.lock.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.replayed = append(r.replayed, true)
+	r.changed = true
+	r.lock.Unlock()
+	return resp, nil
+}
+
+func (r *CassetteRecorder) match(req *CassetteRequest) *CassetteInteraction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	repeated := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !r.opts.Matcher(req, &interaction.Request) {
+			continue
+		}
+		if !r.replayed[i] {
+			r.replayed[i] = true
+			return interaction
+		}
+		if repeated < 0 {
+			repeated = i
+		}
+	}
+	if r.opts.ReplayRepeatedly && repeated >= 0 {
+		return r.cassette.Interactions[repeated]
+	}
+	return nil
+}
+
+func (r *CassetteRecorder) recordRequest(req *http.Request, body []byte) (*CassetteRequest, error) {
+	u := *req.URL
+	if len(r.opts.RedactQueryParams) > 0 {
+		query := u.Query()
+		for _, param := range r.opts.RedactQueryParams {
+			if _, ok := query[param]; ok {
+				query.Set(param, CassetteRedacted)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	recorded := &CassetteRequest{Method: req.Method, URL: u.String(), Header: r.redactHeader(req.Header)}
+	if len(body) > 0 {
+		decoded, err := ReadDecodedBodyWithHeader(body, strings.Join(req.Header.Values(HTTPHeaderContentEncoding), ","))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(decoded, body) {
+			recorded.Header.Del(HTTPHeaderContentEncoding)
+		}
+		body = decoded
+	}
+	recorded.Body, recorded.BodyBase64 = cassetteBody(body)
+	return recorded, nil
+}
+
+func (r *CassetteRecorder) redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	if redacted == nil {
+		redacted = http.Header{}
+	}
+	for _, key := range r.opts.RedactHeaders {
+		key = http.CanonicalHeaderKey(key)
+		if values := redacted[key]; len(values) > 0 {
+			redacted[key] = make([]string, len(values))
+			for i := range values {
+				redacted[key][i] = CassetteRedacted
+			}
+		}
+	}
+	return redacted
+}
+
+// readRequestBody reads the body of req and gives req a fresh copy of it.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ReadAllFromReadCloser(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func replayCassetteResponse(req *http.Request, recorded *CassetteResponse) *http.Response {
+	resp := &http.Response{
+		Status:     strconv.Itoa(recorded.StatusCode) + " " + http.StatusText(recorded.StatusCode),
+		StatusCode: recorded.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     recorded.Header.Clone(),
+		Request:    req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if req.Method == http.MethodHead {
+		// the recorded Content-Length is the one of the representation, not of the empty body
+		resp.Body = http.NoBody
+		resp.ContentLength = -1
+		if length, err := strconv.ParseInt(resp.Header.Get(HTTPHeaderContentLength), 10, 64); err == nil {
+			resp.ContentLength = length
+		}
+		return resp
+	}
+	SetBodyToResponse(resp, recorded.BodyBytes())
+	return resp
+}
+
+func cassetteBody(body []byte) (text, encoded string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+func cassetteBodyBytes(text, encoded string) []byte {
+	if encoded != "" {
+		bs, _ := base64.StdEncoding.DecodeString(encoded)
+		return bs
+	}
+	return []byte(text)
+}
@@ -0,0 +1,145 @@
+package utility
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCassetteRecorder(t *testing.T) {
+	for _, name := range []string{"fixture.yaml", "fixture.json"} {
+		path := filepath.Join(t.TempDir(), "cassettes", name)
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			w.Header().Set(HTTPHeaderContentType, ContentTypeJSON)
+			w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+			zw := gzip.NewWriter(w)
+			_, _ = zw.Write([]byte(`{"user":"` + req.URL.Query().Get("user") + `"}`))
+			_ = zw.Close()
+		}))
+
+		recorder, err := NewCassetteRecorder(CassetteOptions{Path: path, RedactQueryParams: []string{"api_key"}})
+		assert.Nil(t, err)
+		client := NewHTTPClient(HTTPClientOptions{
+			BaseURL:   server.URL,
+			Header:    http.Header{HTTPHeaderAuthorization: {"Bearer secret"}},
+			Transport: recorder,
+		})
+		var out StrMap
+		assert.Nil(t, client.GetJSON(context.Background(), "/users?user=a&api_key=secret", &out))
+		assert.Equal(t, StrMap{"user": "a"}, out)
+		assert.Nil(t, recorder.Save())
+		server.Close()
+
+		bs, err := ReadFileFromPath(path)
+		assert.Nil(t, err)
+		assert.False(t, strings.Contains(string(bs), "secret"), string(bs))
+		cassette, err := LoadCassette(path)
+		assert.Nil(t, err)
+		interaction := cassette.Interactions[0]
+		assert.Equal(t, CassetteRedacted, interaction.Request.Header.Get(HTTPHeaderAuthorization))
+		assert.Equal(t, CassetteRedacted, interaction.Response.Header.Get("Set-Cookie"))
+		assert.Equal(t, "", interaction.Response.Header.Get(HTTPHeaderContentEncoding))
+		assert.Equal(t, `{"user":"a"}`, interaction.Response.Body)
+
+		// replay offline, the server is closed
+		recorder, err = NewCassetteRecorder(CassetteOptions{Path: path, Mode: CassetteReplay, RedactQueryParams: []string{"api_key"}})
+		assert.Nil(t, err)
+		client = NewHTTPClient(HTTPClientOptions{BaseURL: server.URL, Transport: recorder})
+		out = nil
+		assert.Nil(t, client.GetJSON(context.Background(), "/users?user=a&api_key=other", &out))
+		assert.Equal(t, StrMap{"user": "a"}, out)
+		assert.Equal(t, 1, calls)
+
+		// each interaction is replayed once
+		err = client.GetJSON(context.Background(), "/users?user=a&api_key=other", &out)
+		assert.True(t, errors.Is(err, ErrCassetteNoMatch))
+	}
+}
+
+func TestCassetteMatchers(t *testing.T) {
+	recorded := &CassetteRequest{Method: "POST", URL: "http://x/a?b=1", Header: http.Header{"X-V": {"1"}}, Body: "body"}
+	req := &CassetteRequest{Method: "POST", URL: "http://x/a?b=2", Header: http.Header{"X-V": {"1"}}, BodyBase64: "Ym9keQ=="}
+	assert.False(t, MatchCassetteMethodAndURL(req, recorded))
+	assert.True(t, MatchCassetteAll(MatchCassetteMethodAndPath, MatchCassetteBody, MatchCassetteHeaders("X-V"))(req, recorded))
+	req.Header.Set("X-V", "2")
+	assert.False(t, MatchCassetteHeaders("X-V")(req, recorded))
+
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	cassette := &Cassette{Interactions: []*CassetteInteraction{{Request: *recorded, Response: CassetteResponse{StatusCode: 201, BodyBase64: "AP8="}}}}
+	assert.Nil(t, cassette.Save(path))
+	recorder, err := NewCassetteRecorder(CassetteOptions{Path: path, ReplayRepeatedly: true})
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		httpReq, _ := http.NewRequest("POST", "http://x/a?b=1", strings.NewReader("ignored"))
+		resp, err := recorder.RoundTrip(httpReq)
+		assert.Nil(t, err)
+		assert.Equal(t, 201, resp.StatusCode)
+		body, _ := ReadAllFromReadCloser(resp.Body)
+		assert.Equal(t, []byte{0, 0xff}, body)
+	}
+	_, err = NewCassetteRecorder(CassetteOptions{Path: filepath.Join(t.TempDir(), "missing.yaml"), Mode: CassetteReplay})
+	assert.NotNil(t, err)
+}
+
+func TestCassetteRecorderBodylessResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+		switch req.URL.Path {
+		case "/204":
+			w.WriteHeader(http.StatusNoContent)
+		case "/304":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set(HTTPHeaderContentLength, "42")
+		}
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	recorder, err := NewCassetteRecorder(CassetteOptions{Path: path, Mode: CassetteRecord})
+	assert.Nil(t, err)
+	client := &http.Client{Transport: recorder}
+	for _, c := range []struct {
+		method, path string
+		status       int
+	}{{http.MethodHead, "/", 200}, {http.MethodGet, "/204", 204}, {http.MethodGet, "/304", 304}} {
+		req, _ := http.NewRequest(c.method, server.URL+c.path, nil)
+		resp, err := client.Do(req)
+		assert.Nil(t, err, c.path)
+		assert.Equal(t, c.status, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+	interactions := recorder.Cassette().Interactions
+	assert.Equal(t, 3, len(interactions))
+	assert.Equal(t, "gzip", interactions[0].Response.Header.Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, "42", interactions[0].Response.Header.Get(HTTPHeaderContentLength))
+
+	// HEAD replays keep the recorded length
+	assert.Nil(t, recorder.Save())
+	replayer, err := NewCassetteRecorder(CassetteOptions{Path: path, Mode: CassetteReplay})
+	assert.Nil(t, err)
+	req, _ := http.NewRequest(http.MethodHead, server.URL+"/", nil)
+	resp, err := replayer.RoundTrip(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, int64(42), resp.ContentLength)
+	assert.Equal(t, "42", resp.Header.Get(HTTPHeaderContentLength))
+	assert.Equal(t, "gzip", resp.Header.Get(HTTPHeaderContentEncoding))
+
+	// a request body that can't be decoded isn't recorded as empty
+	req, _ = http.NewRequest(http.MethodPost, server.URL, strings.NewReader("not gzip"))
+	req.Header.Set(HTTPHeaderContentEncoding, "gzip")
+	_, err = client.Do(req)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, len(recorder.Cassette().Interactions))
+}
@@ -0,0 +1,173 @@
+package utility
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HTTPHeaderForwarded     = "Forwarded"
+	HTTPHeaderXForwardedFor = "X-Forwarded-For"
+	HTTPHeaderXRealIP       = "X-Real-IP"
+)
+
+// TrustedProxies are the proxies whose forwarding header is believed.
+// The zero value trusts no proxy.
+type TrustedProxies struct {
+	Networks []*net.IPNet
+	// Header is the forwarding header the proxies set, default X-Forwarded-For. It is the only
+	// one read: proxies pass the other ones from clients through, so they could be spoofed.
+	// Forwarded (RFC 7239) and X-Real-IP are parsed as such, other headers as X-Forwarded-For.
+	Header string
+}
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8", a plain IP is taken as a single address network.
+// The proxies set X-Forwarded-For, change Header for others.
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	proxies := TrustedProxies{Networks: make([]*net.IPNet, 0, len(cidrs))}
+	for _, cidr := range cidrs {
+		network, err := ParseNetwork(cidr)
+		if err != nil {
+			return TrustedProxies{}, err
+		}
+		proxies.Networks = append(proxies.Networks, network)
+	}
+	return proxies, nil
+}
+
+func (t TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range t.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t TrustedProxies) header() string {
+	if t.Header == "" {
+		return HTTPHeaderXForwardedFor
+	}
+	return http.CanonicalHeaderKey(t.Header)
+}
+
+// ClientIP returns the IP of the client that originated req, nil if RemoteAddr can't be parsed.
+// The forwarding header of trustedProxies is only believed from trusted proxies: starting from
+// the peer, its chain of addresses is walked from the nearest hop and the first address not
+// trusted is the client. An unparsable or obfuscated hop ends the walk at the proxy that reported it.
+func ClientIP(req *http.Request, trustedProxies TrustedProxies) net.IP {
+	ip := RequestRemoteIP(req)
+	if ip == nil || !trustedProxies.Contains(ip) {
+		return ip
+	}
+	chain := forwardedForChain(req.Header, trustedProxies.header())
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := parseForwardedNode(chain[i])
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !trustedProxies.Contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// ClientIPKeyFunc keys requests by ClientIP, e.g. for NewRateLimitMiddleware behind proxies.
+func ClientIPKeyFunc(trustedProxies TrustedProxies) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		if ip := ClientIP(req, trustedProxies); ip != nil {
+			return ip.String()
+		}
+		return req.RemoteAddr
+	}
+}
+
+// forwardedForChain returns the forwarded addresses of header, the farthest first.
+func forwardedForChain(header http.Header, name string) []string {
+	var chain []string
+	switch name {
+	case HTTPHeaderForwarded:
+		for _, value := range header.Values(HTTPHeaderForwarded) {
+			for _, element := range splitQuoted(value, ',') {
+				node := ""
+				for _, pair := range splitQuoted(element, ';') {
+					key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						node = strings.Trim(value, `"`)
+					}
+				}
+				chain = append(chain, node)
+			}
+		}
+	case HTTPHeaderXRealIP:
+		if value := strings.TrimSpace(header.Get(HTTPHeaderXRealIP)); value != "" {
+			chain = append(chain, value)
+		}
+	default:
+		for _, value := range header.Values(name) {
+			for _, node := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(node))
+			}
+		}
+	}
+	return chain
+}
+
+// splitQuoted splits s by sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseForwardedNode parses "192.0.2.43", "192.0.2.43:47011", "[2001:db8::1]:4711" or "2001:db8::1",
+// nil for "unknown" and obfuscated identifiers.
+func parseForwardedNode(node string) net.IP {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return net.ParseIP(node[1:end])
+		}
+		return nil
+	}
+	if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	return net.ParseIP(node)
+}
+
+// IsLoopbackIP reports whether ip is in 127.0.0.0/8 or is ::1, including IPv4-mapped forms.
+func IsLoopbackIP(ip net.IP) bool {
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsPrivateIP reports whether ip is loopback, in a private range (RFC 1918, RFC 4193)
+// or link-local.
+func IsPrivateIP(ip net.IP) bool {
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
+
+// IsClientLocalhost reports whether the ClientIP of req is a loopback address.
+func IsClientLocalhost(req *http.Request, trustedProxies TrustedProxies) bool {
+	return IsLoopbackIP(ClientIP(req, trustedProxies))
+}
+
+// IsRequestFromPrivateNetwork reports whether the ClientIP of req is private, see IsPrivateIP.
+func IsRequestFromPrivateNetwork(req *http.Request, trustedProxies TrustedProxies) bool {
+	return IsPrivateIP(ClientIP(req, trustedProxies))
+}
@@ -0,0 +1,87 @@
+package utility
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "fd00::1")
+	assert.Nil(t, err)
+	_, err = ParseTrustedProxies("proxy")
+	assert.NotNil(t, err)
+
+	newRequest := func(remoteAddr string, header http.Header) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return req
+	}
+	cases := []struct {
+		proxyHeader string
+		remoteAddr  string
+		header      http.Header
+		ip          string
+	}{
+		// untrusted peers can't spoof
+		{"", "203.0.113.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1"}}, "203.0.113.1"},
+		{"", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1, 2.2.2.2, 10.0.0.2"}}, "2.2.2.2"},
+		{"", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1", "10.0.0.3, 10.0.0.2"}}, "1.1.1.1"},
+		{"", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3"}}, "10.0.0.3"},
+		{"", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"unknown"}}, "10.0.0.1"},
+		{"", "10.0.0.1:1234", nil, "10.0.0.1"},
+		// headers other than the one of the proxies are passed through from clients
+		{"", "10.0.0.1:1234", http.Header{"Forwarded": {"for=127.0.0.1"}, "X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"", "10.0.0.1:1234", http.Header{"Forwarded": {"for=127.0.0.1"}}, "10.0.0.1"},
+		{"", "10.0.0.1:1234", http.Header{"X-Real-Ip": {"127.0.0.1"}}, "10.0.0.1"},
+		{"X-Real-IP", "10.0.0.1:1234", http.Header{"X-Real-Ip": {"3.3.3.3"}, "X-Forwarded-For": {"127.0.0.1"}}, "3.3.3.3"},
+		{"x-real-ip", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Forwarded", "[fd00::1]:1234", http.Header{
+			"Forwarded":       {`for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`},
+			"X-Forwarded-For": {"4.4.4.4"},
+		}, "2001:db8:cafe::17"},
+		{"Forwarded", "[fd00::1]:1234", http.Header{"Forwarded": {`for="10.0.0.5:80", for=_hidden;by="x,y"`}}, "fd00::1"},
+		{"Forwarded", "10.0.0.1:1234", http.Header{"Forwarded": {`for=192.0.2.60`, `For="10.0.0.9"`}}, "192.0.2.60"},
+		{"Forwarded", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"127.0.0.1"}}, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		proxies := proxies
+		proxies.Header = c.proxyHeader
+		assert.Equal(t, net.ParseIP(c.ip).String(), ClientIP(newRequest(c.remoteAddr, c.header), proxies).String(), c)
+	}
+	assert.Nil(t, ClientIP(newRequest("pipe", nil), TrustedProxies{}))
+	assert.Equal(t, "203.0.113.1", ClientIP(newRequest("203.0.113.1:1", http.Header{"X-Forwarded-For": {"2.2.2.2"}}), TrustedProxies{}).String())
+	assert.Nil(t, ClientIP(newRequest("pipe", nil), proxies))
+	assert.Equal(t, "2.2.2.2", ClientIPKeyFunc(proxies)(newRequest("10.0.0.1:1", http.Header{"X-Forwarded-For": {"2.2.2.2"}})))
+}
+
+func TestIsRequestFromLocalhost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.RemoteAddr = "203.0.113.1:1234"
+	assert.False(t, IsRequestFromLocalhost(req))
+	req.RemoteAddr = "127.0.0.1:1234"
+	assert.True(t, IsRequestFromLocalhost(req))
+	req.RemoteAddr = "[::1]:1234"
+	assert.True(t, IsRequestFromLocalhost(req))
+	req.Header.Set(HTTPHeaderXForwardedFor, "203.0.113.1")
+	assert.False(t, IsRequestFromLocalhost(req))
+
+	proxies, _ := ParseTrustedProxies("::1")
+	assert.False(t, IsClientLocalhost(req, proxies))
+	req.Header.Set(HTTPHeaderXForwardedFor, "::1")
+	assert.True(t, IsClientLocalhost(req, proxies))
+	req.Header.Set(HTTPHeaderXForwardedFor, "203.0.113.9")
+	req.Header.Set(HTTPHeaderForwarded, "for=127.0.0.1")
+	assert.False(t, IsClientLocalhost(req, proxies), "spoofed Forwarded header")
+	req.Header.Del(HTTPHeaderForwarded)
+	req.Header.Set(HTTPHeaderXForwardedFor, "192.168.1.2")
+	assert.True(t, IsRequestFromPrivateNetwork(req, proxies))
+	req.Header.Set(HTTPHeaderXForwardedFor, "8.8.8.8")
+	assert.False(t, IsRequestFromPrivateNetwork(req, proxies))
+}
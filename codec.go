@@ -0,0 +1,207 @@
+package utility
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	CodecGZip    = "gzip"
+	CodecZlib    = "zlib"
+	CodecDeflate = "deflate"
+	CodecLZW     = "lzw"
+	CodecBZip2   = "bzip2"
+
+	// CodecDefaultLevel selects the default compression level of a codec.
+	CodecDefaultLevel = -1
+)
+
+var ErrCodecDecodeOnly = NewError("codec can only decode").WithCode("codec_decode_only").WithCategory(ErrorCategoryInvalidArgument)
+
+// Codec is a compression format.
+// Third-party formats such as zstd or brotli are added with RegisterCodec.
+type Codec struct {
+	// Name is the registry key, e.g. "gzip".
+	Name string
+	// ContentEncodings are the HTTP Content-Encoding tokens of the codec, e.g. "gzip" and "x-gzip".
+	ContentEncodings []string
+	// Magic are the byte prefixes identifying data of the codec, used by DetectCodec.
+	Magic     [][]byte
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter is nil for a decode-only codec, level is codec specific, CodecDefaultLevel selects its default.
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// CanEncode reports whether the codec has a writer.
+func (c *Codec) CanEncode() bool {
+	return c.NewWriter != nil
+}
+
+// Encode compresses in with level.
+func (c *Codec) Encode(in []byte, level int) ([]byte, error) {
+	if c.NewWriter == nil {
+		return nil, ErrCodecDecodeOnly.WithField("codec", c.Name)
+	}
+	var buffer bytes.Buffer
+	writer, err := c.NewWriter(&buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(in)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode decompresses in.
+func (c *Codec) Decode(in []byte) ([]byte, error) {
+	reader, err := c.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ReadBytes(reader)
+}
+
+type codecRegistry struct {
+	lock       sync.RWMutex
+	byName     map[string]*Codec
+	byEncoding map[string]*Codec
+}
+
+var codecs = &codecRegistry{
+	byName:     make(map[string]*Codec),
+	byEncoding: make(map[string]*Codec),
+}
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:             CodecGZip,
+		ContentEncodings: []string{"gzip", "x-gzip"},
+		Magic:            [][]byte{{0x1f, 0x8b}},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	})
+	// HTTP "deflate" is the zlib format (RFC 9110 8.4.1.2)
+	RegisterCodec(&Codec{
+		Name:             CodecZlib,
+		ContentEncodings: []string{"deflate"},
+		Magic:            [][]byte{{0x78, 0x01}, {0x78, 0x5e}, {0x78, 0x9c}, {0x78, 0xda}},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+	})
+	// raw deflate has no header to detect it by
+	RegisterCodec(&Codec{
+		Name: CodecDeflate,
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+	})
+	// LSB order with 8 bit literals as in GIF and PDF, not the .Z format of compress(1)
+	RegisterCodec(&Codec{
+		Name: CodecLZW,
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return lzw.NewReader(r, lzw.LSB, 8), nil
+		},
+		NewWriter: func(w io.Writer, _ int) (io.WriteCloser, error) {
+			return lzw.NewWriter(w, lzw.LSB, 8), nil
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:  CodecBZip2,
+		Magic: [][]byte{[]byte("BZh")},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		},
+	})
+}
+
+// RegisterCodec adds codec to the registry, replacing any codec of the same name or content encoding.
+func RegisterCodec(codec *Codec) {
+	codecs.lock.Lock()
+	defer codecs.lock.Unlock()
+	name := strings.ToLower(codec.Name)
+	if old := codecs.byName[name]; old != nil {
+		for _, encoding := range old.ContentEncodings {
+			delete(codecs.byEncoding, strings.ToLower(encoding))
+		}
+	}
+	codecs.byName[name] = codec
+	for _, encoding := range codec.ContentEncodings {
+		codecs.byEncoding[strings.ToLower(encoding)] = codec
+	}
+}
+
+// CodecByName returns the codec registered as name, case-insensitive, nil if there is none.
+func CodecByName(name string) *Codec {
+	codecs.lock.RLock()
+	defer codecs.lock.RUnlock()
+	return codecs.byName[strings.ToLower(name)]
+}
+
+// CodecByContentEncoding returns the codec of an HTTP Content-Encoding token, case-insensitive,
+// nil if there is none, e.g. for "identity".
+func CodecByContentEncoding(token string) *Codec {
+	codecs.lock.RLock()
+	defer codecs.lock.RUnlock()
+	return codecs.byEncoding[strings.ToLower(strings.TrimSpace(token))]
+}
+
+// DetectCodec returns the codec whose magic bytes start data, nil if none does.
+func DetectCodec(data []byte) *Codec {
+	codecs.lock.RLock()
+	defer codecs.lock.RUnlock()
+	var found *Codec
+	longest := 0
+	for _, codec := range codecs.byName {
+		for _, magic := range codec.Magic {
+			if len(magic) > longest && bytes.HasPrefix(data, magic) {
+				found, longest = codec, len(magic)
+			}
+		}
+	}
+	return found
+}
+
+// Codecs returns the registered codecs sorted by name.
+func Codecs() []*Codec {
+	codecs.lock.RLock()
+	defer codecs.lock.RUnlock()
+	all := make([]*Codec, 0, len(codecs.byName))
+	for _, codec := range codecs.byName {
+		all = append(all, codec)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// isZlibHeader reports whether data starts with a valid zlib header (RFC 1950 2.2).
+func isZlibHeader(data []byte) bool {
+	return len(data) >= 2 && data[0]&0x0f == 8 && data[0]>>4 <= 7 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
@@ -0,0 +1,86 @@
+package utility
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	plain := []byte(strings.Repeat("codec registry ", 100))
+	for _, name := range []string{CodecGZip, CodecZlib, CodecDeflate, CodecLZW} {
+		codec := CodecByName(name)
+		assert.NotNil(t, codec, name)
+		encoded, err := codec.Encode(plain, CodecDefaultLevel)
+		assert.Nil(t, err, name)
+		decoded, err := codec.Decode(encoded)
+		assert.Nil(t, err, name)
+		assert.Equal(t, plain, decoded, name)
+		if len(codec.Magic) > 0 {
+			assert.Equal(t, codec, DetectCodec(encoded), name)
+		}
+	}
+
+	bzip2 := CodecByName("BZIP2")
+	assert.False(t, bzip2.CanEncode())
+	_, err := bzip2.Encode(plain, CodecDefaultLevel)
+	assert.True(t, errors.Is(err, ErrCodecDecodeOnly))
+	assert.Equal(t, bzip2, DetectCodec([]byte("BZh91AY&SY")))
+	assert.Nil(t, DetectCodec([]byte("plain")))
+}
+
+func TestCodecContentEncoding(t *testing.T) {
+	assert.Equal(t, CodecGZip, CodecByContentEncoding("X-GZip").Name)
+	assert.Equal(t, CodecZlib, CodecByContentEncoding("deflate").Name)
+	assert.Nil(t, CodecByContentEncoding("identity"))
+
+	plain := []byte("hello")
+	zlibBody, _ := CodecByName(CodecZlib).Encode(plain, CodecDefaultLevel)
+	rawBody, _ := CodecByName(CodecDeflate).Encode(plain, flate.BestSpeed)
+	for _, body := range [][]byte{zlibBody, rawBody} {
+		decoded, err := ReadDecodedBodyWithHeader(body, "deflate")
+		assert.Nil(t, err)
+		assert.Equal(t, plain, decoded)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	identity := &Codec{
+		Name:             "test-identity",
+		ContentEncodings: []string{"x-test-identity"},
+		Magic:            [][]byte{[]byte("TID")},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	}
+	RegisterCodec(identity)
+	assert.Equal(t, identity, CodecByContentEncoding("x-test-identity"))
+	assert.Equal(t, identity, DetectCodec([]byte("TID body")))
+	decoded, err := ReadDecodedBodyWithHeader([]byte("TID body"), "x-test-identity")
+	assert.Nil(t, err)
+	assert.Equal(t, "TID body", string(decoded))
+
+	var names []string
+	for _, codec := range Codecs() {
+		names = append(names, codec.Name)
+	}
+	assert.Contains(t, names, "test-identity")
+}
+
+func TestZipWriterUsesCodec(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewZipArchiveWriter(&buf)
+	_, err := writer.WriteBytes([]byte(strings.Repeat("zip ", 100)), "a.txt", true)
+	assert.Nil(t, err)
+	writer.Close()
+	reader, err := NewZipArchiveReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	file, err := reader.ReadNextFile()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("zip ", 100), string(file.Content))
+}
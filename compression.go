@@ -0,0 +1,313 @@
+package utility
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	HTTPHeaderAcceptEncoding = "Accept-Encoding"
+	HTTPHeaderVary           = "Vary"
+	HTTPHeaderETag           = "ETag"
+
+	defaultCompressionMinSize = 1024
+)
+
+// DefaultCompressibleContentTypes are the media types, or prefixes ending with "/", compressed by default.
+var DefaultCompressibleContentTypes = []string{
+	"text/",
+	ContentTypeJSON,
+	"application/javascript",
+	"application/xml",
+	"application/x-www-form-urlencoded",
+	"image/svg+xml",
+}
+
+type CompressionOptions struct {
+	// Encodings are the Content-Encoding tokens offered, in server preference order, default "gzip", "deflate".
+	Encodings []string
+	// Level is passed to the codec, default CodecDefaultLevel.
+	Level int
+	// MinSize is the body size from which responses are compressed, default 1024.
+	MinSize int
+	// ContentTypes are compressible media types, default DefaultCompressibleContentTypes.
+	ContentTypes []string
+}
+
+type acceptedEncoding struct {
+	token string
+	q     float64
+}
+
+// NegotiateContentEncoding picks the best of available (in preference order) for an
+// Accept-Encoding header value, honoring q-values and "*". It returns "" for identity.
+func NegotiateContentEncoding(acceptEncoding string, available []string) string {
+	var accepted []acceptedEncoding
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		token := strings.ToLower(strings.TrimSpace(params[0]))
+		if token == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted = append(accepted, acceptedEncoding{token: token, q: q})
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range available {
+		q, wildcard := -1.0, -1.0
+		for _, a := range accepted {
+			if a.token == strings.ToLower(encoding) {
+				q = a.q
+			} else if a.token == "*" {
+				wildcard = a.q
+			}
+		}
+		if q < 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// NewCompressionMiddleware compresses responses with the encoding negotiated from Accept-Encoding.
+// Responses are compressed only if their body reaches MinSize (or is flushed before) and their
+// Content-Type is compressible; responses already encoded, partial, or without body are left as is.
+// Vary: Accept-Encoding is added to every response.
+func NewCompressionMiddleware(opts CompressionOptions) func(http.Handler) http.Handler {
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{"gzip", "deflate"}
+	}
+	if opts.Level == 0 {
+		opts.Level = CodecDefaultLevel
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultCompressionMinSize
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = DefaultCompressibleContentTypes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Add(HTTPHeaderVary, HTTPHeaderAcceptEncoding)
+			encoding := NegotiateContentEncoding(req.Header.Get(HTTPHeaderAcceptEncoding), opts.Encodings)
+			codec := CodecByContentEncoding(encoding)
+			if req.Method == http.MethodHead || codec == nil || !codec.CanEncode() {
+				next.ServeHTTP(w, req)
+				return
+			}
+			cw := &compressResponseWriter{ResponseWriter: w, opts: &opts, encoding: encoding, codec: codec}
+			next.ServeHTTP(cw, req)
+			// not deferred: on panic the buffered body is dropped, so a recovery middleware
+			// can still write its error response
+			cw.close()
+		})
+	}
+}
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	opts     *CompressionOptions
+	encoding string
+	codec    *Codec
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	writer  io.WriteCloser
+	// hijacked or failed connections are not written anymore
+	detached bool
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		// informational responses go out as is
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.detached {
+		return 0, http.ErrHijacked
+	}
+	if !w.decided {
+		w.buf.Write(p)
+		if w.buf.Len() < w.opts.MinSize {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.writer != nil {
+		return w.writer.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressResponseWriter) Flush() {
+	if w.detached {
+		return
+	}
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported by the response writer")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.detached = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the header, compressing the body if allowed, then the buffered body.
+func (w *compressResponseWriter) decide(large bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get(HTTPHeaderContentType) == "" && w.buf.Len() > 0 {
+		header.Set(HTTPHeaderContentType, http.DetectContentType(w.buf.Bytes()))
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if large && w.shouldCompress(status) {
+		header.Set(HTTPHeaderContentEncoding, w.encoding)
+		header.Del(HTTPHeaderContentLength)
+		if etag := header.Get(HTTPHeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set(HTTPHeaderETag, "W/"+etag)
+		}
+		writer, err := w.codec.NewWriter(w.ResponseWriter, w.opts.Level)
+		if err != nil {
+			header.Del(HTTPHeaderContentEncoding)
+		} else {
+			w.writer = writer
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.writer != nil {
+		_, err = w.writer.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf = bytes.Buffer{}
+	return err
+}
+
+func (w *compressResponseWriter) shouldCompress(status int) bool {
+	header := w.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || header.Get(HTTPHeaderContentEncoding) != "" ||
+		header.Get("Content-Range") != "" {
+		return false
+	}
+	return isCompressibleContentType(header.Get(HTTPHeaderContentType), w.opts.ContentTypes)
+}
+
+func (w *compressResponseWriter) close() {
+	if w.detached {
+		return
+	}
+	if !w.decided {
+		_ = w.decide(false)
+	}
+	if w.writer != nil {
+		_ = w.writer.Close()
+	}
+}
+
+func isCompressibleContentType(contentType string, types []string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRequestDecompressionMiddleware decodes request bodies sent with a Content-Encoding, see NewContentDecodingReader.
+// Requests of an unsupported encoding are answered 415 Unsupported Media Type, bodies decoding beyond
+// maxSize fail to read with *DecodedSizeLimitError if maxSize > 0.
+func NewRequestDecompressionMiddleware(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			contentEncoding := strings.Join(req.Header.Values(HTTPHeaderContentEncoding), ",")
+			if len(ParseContentEncoding(contentEncoding)) == 0 || req.Body == nil || req.Body == http.NoBody {
+				next.ServeHTTP(w, req)
+				return
+			}
+			body, err := NewContentDecodingReader(req.Body, contentEncoding, maxSize)
+			if err != nil {
+				if errors.Is(err, ErrUnsupportedContentEncoding) {
+					w.Header().Set(HTTPHeaderAcceptEncoding, strings.Join(supportedContentEncodings(), ", "))
+					http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				} else {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+				return
+			}
+			req.Body = body
+			req.ContentLength = -1
+			req.Header.Del(HTTPHeaderContentEncoding)
+			req.Header.Del(HTTPHeaderContentLength)
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func supportedContentEncodings() []string {
+	var encodings []string
+	for _, codec := range Codecs() {
+		encodings = append(encodings, codec.ContentEncodings...)
+	}
+	sort.Strings(encodings)
+	return encodings
+}
@@ -0,0 +1,120 @@
+package utility
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	available := []string{"gzip", "deflate"}
+	assert.Equal(t, "gzip", NegotiateContentEncoding("gzip, deflate", available))
+	assert.Equal(t, "deflate", NegotiateContentEncoding("gzip;q=0.5, deflate", available))
+	assert.Equal(t, "deflate", NegotiateContentEncoding("GZIP;q=0, *", available))
+	assert.Equal(t, "gzip", NegotiateContentEncoding("br, *;q=0.1", available))
+	assert.Equal(t, "", NegotiateContentEncoding("br", available))
+	assert.Equal(t, "", NegotiateContentEncoding("", available))
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+	handler := NewCompressionMiddleware(CompressionOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set(HTTPHeaderContentType, ContentTypeJSON)
+			w.Header().Set(HTTPHeaderETag, `"v1"`)
+			_, _ = io.WriteString(w, large[:100])
+			_, _ = io.WriteString(w, large[100:])
+		case "/small":
+			w.Header().Set(HTTPHeaderContentType, ContentTypeText)
+			_, _ = io.WriteString(w, "small")
+		case "/binary":
+			w.Header().Set(HTTPHeaderContentType, ContentTypeBIN)
+			_, _ = io.WriteString(w, large)
+		case "/encoded":
+			w.Header().Set(HTTPHeaderContentType, ContentTypeText)
+			w.Header().Set(HTTPHeaderContentEncoding, "br")
+			_, _ = io.WriteString(w, large)
+		case "/panic":
+			w.Header().Set(HTTPHeaderContentType, ContentTypeText)
+			_, _ = io.WriteString(w, "partial")
+			panic("boom")
+		case "/flush":
+			_, _ = io.WriteString(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	handler = NewRecoveryMiddleware(func(*http.Request, *Error) {})(handler)
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(HTTPHeaderAcceptEncoding, acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/large", "deflate;q=0.5, gzip")
+	assert.Equal(t, "gzip", rec.Header().Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, HTTPHeaderAcceptEncoding, rec.Header().Get(HTTPHeaderVary))
+	assert.Equal(t, `W/"v1"`, rec.Header().Get(HTTPHeaderETag))
+	plain, err := GZipDecompress(rec.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, large, string(plain))
+
+	rec = serve("/large", "deflate")
+	assert.Equal(t, "deflate", rec.Header().Get(HTTPHeaderContentEncoding))
+	plain, err = ReadDecodedBodyWithHeader(rec.Body.Bytes(), "deflate")
+	assert.Nil(t, err)
+	assert.Equal(t, large, string(plain))
+
+	rec = serve("/large", "")
+	assert.Equal(t, "", rec.Header().Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, large, rec.Body.String())
+
+	for _, path := range []string{"/small", "/binary"} {
+		rec = serve(path, "gzip")
+		assert.Equal(t, "", rec.Header().Get(HTTPHeaderContentEncoding), path)
+		assert.Equal(t, HTTPHeaderAcceptEncoding, rec.Header().Get(HTTPHeaderVary), path)
+	}
+	rec = serve("/encoded", "gzip")
+	assert.Equal(t, "br", rec.Header().Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, large, rec.Body.String())
+
+	// the partial body of a panicking handler isn't flushed before the recovery
+	rec = serve("/panic", "gzip")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "partial")
+
+	rec = serve("/flush", "gzip")
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "gzip", rec.Header().Get(HTTPHeaderContentEncoding))
+	plain, err = GZipDecompress(rec.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "data: 1\n\n", string(plain))
+}
+
+func TestRequestDecompressionMiddleware(t *testing.T) {
+	handler := NewRequestDecompressionMiddleware(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	compressed, _ := GZipCompress([]byte("request body"))
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed))
+	req.Header.Set(HTTPHeaderContentEncoding, "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "request body", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed))
+	req.Header.Set(HTTPHeaderContentEncoding, "br")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.Contains(t, rec.Header().Get(HTTPHeaderAcceptEncoding), "gzip")
+}
@@ -0,0 +1,133 @@
+package utility
+
+import (
+	"bytes"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeYAML decodes a YAML document into StrMap.
+// Nested mappings with non-string keys (map[interface{}]interface{}) are
+// normalized through AnyToStrMap, so the result can be fed into FlattenMap
+// and the FindInStrMap helpers directly.
+func DecodeYAML(bs []byte) (StrMap, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(bs, &v); err != nil {
+		return nil, err
+	}
+	return normalizedStrMap(v), nil
+}
+
+// EncodeYAML encodes m as a YAML document, keys are sorted at every level.
+func EncodeYAML(m StrMap) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(normalizedStrMap(m)); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func LoadYAMLFile(path string) (StrMap, error) {
+	bs, err := ReadFileFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeYAML(bs)
+}
+
+func SaveYAMLFile(m StrMap, path string) error {
+	bs, err := EncodeYAML(m)
+	if err != nil {
+		return err
+	}
+	_, err = WriteBytesToFile(bs, path)
+	return err
+}
+
+// DecodeTOML decodes a TOML document into StrMap.
+// Arrays of tables are returned as []interface{} of StrMap, like YAML sequences.
+func DecodeTOML(bs []byte) (StrMap, error) {
+	var v map[string]interface{}
+	if err := toml.Unmarshal(bs, &v); err != nil {
+		return nil, err
+	}
+	return normalizedStrMap(v), nil
+}
+
+// EncodeTOML encodes m as a TOML document, keys are sorted at every level.
+// TOML has no null, so nil values are omitted.
+func EncodeTOML(m StrMap) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = ""
+	if err := encoder.Encode(normalizedStrMap(m)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func LoadTOMLFile(path string) (StrMap, error) {
+	bs, err := ReadFileFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTOML(bs)
+}
+
+func SaveTOMLFile(m StrMap, path string) error {
+	bs, err := EncodeTOML(m)
+	if err != nil {
+		return err
+	}
+	_, err = WriteBytesToFile(bs, path)
+	return err
+}
+
+// normalizedStrMap converts value to StrMap recursively, an empty document results in an empty StrMap.
+func normalizedStrMap(value interface{}) StrMap {
+	m, ok := normalizedMapValue(value).(StrMap)
+	if !ok || m == nil {
+		return StrMap{}
+	}
+	return m
+}
+
+func normalizedMapValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case StrMap:
+		m := make(StrMap, len(v))
+		for k, item := range v {
+			m[k] = normalizedMapValue(item)
+		}
+		return m
+	case AnyMap:
+		m := AnyToStrMap(v)
+		if m == nil {
+			return StrMap{}
+		}
+		for k, item := range m {
+			m[k] = normalizedMapValue(item)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = normalizedMapValue(item)
+		}
+		return arr
+	case []map[string]interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = normalizedMapValue(item)
+		}
+		return arr
+	default:
+		return value
+	}
+}
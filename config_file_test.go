@@ -0,0 +1,84 @@
+package utility
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	doc := `
+name: demo
+server:
+  port: 8080
+  hosts: [a, b]
+codes:
+  1: one
+  2: two
+`
+	m, err := DecodeYAML([]byte(doc))
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, FindInStrMap(m, "server", "port"))
+	assert.Equal(t, "one", FindInStrMap(m, "codes", "1"))
+	assert.Equal(t, "two", FlattenMap("", ".", m)["codes.2"])
+
+	bs, err := EncodeYAML(m)
+	assert.Nil(t, err)
+	assert.Equal(t, "codes:\n  \"1\": one\n  \"2\": two\nname: demo\nserver:\n  hosts:\n    - a\n    - b\n  port: 8080\n", string(bs))
+
+	again, err := DecodeYAML(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, m, again)
+}
+
+func TestTOMLRoundTrip(t *testing.T) {
+	doc := `
+name = "demo"
+
+[server]
+port = 8080
+hosts = ["a", "b"]
+
+[[users]]
+id = 1
+
+[[users]]
+id = 2
+`
+	m, err := DecodeTOML([]byte(doc))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8080), FindInStrMap(m, "server", "port"))
+	users := m["users"].([]interface{})
+	assert.Equal(t, int64(2), FindInStrMap(users[1].(StrMap), "id"))
+
+	bs, err := EncodeTOML(m)
+	assert.Nil(t, err)
+	again, err := DecodeTOML(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, m, again)
+
+	bs2, err := EncodeTOML(again)
+	assert.Nil(t, err)
+	assert.Equal(t, string(bs), string(bs2))
+}
+
+func TestConfigFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "config_file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := StrMap{"a": StrMap{"b": "c"}, "n": int64(1)}
+	yamlPath := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, SaveYAMLFile(m, yamlPath))
+	loaded, err := LoadYAMLFile(yamlPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "c", FindInStrMap(loaded, "a", "b"))
+
+	tomlPath := filepath.Join(dir, "config.toml")
+	assert.Nil(t, SaveTOMLFile(m, tomlPath))
+	loaded, err = LoadTOMLFile(tomlPath)
+	assert.Nil(t, err)
+	assert.Equal(t, m, loaded)
+}
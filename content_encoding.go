@@ -0,0 +1,159 @@
+package utility
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const ErrorCodeUnsupportedContentEncoding ErrorCode = "unsupported_content_encoding"
+
+var ErrUnsupportedContentEncoding = NewError("unsupported content encoding").
+	WithCode(ErrorCodeUnsupportedContentEncoding).WithCategory(ErrorCategoryInvalidArgument)
+
+// DecodedSizeLimitError is returned when decoded content exceeds the configured limit.
+type DecodedSizeLimitError struct {
+	Limit int64
+}
+
+func (e *DecodedSizeLimitError) Error() string {
+	return fmt.Sprintf("decoded content exceeds limit of %d bytes", e.Limit)
+}
+
+// ParseContentEncoding splits a Content-Encoding header value into lowercase tokens
+// in the order they were applied, dropping "identity".
+func ParseContentEncoding(contentEncoding string) []string {
+	var tokens []string
+	for _, token := range strings.Split(contentEncoding, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token != "" && token != "identity" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// NewContentDecodingReader streams body decoded from contentEncoding, which may list
+// stacked encodings such as "gzip, br": they are undone from the last to the first.
+// "deflate" is decoded as zlib (RFC 9110) or raw deflate, whichever the data is.
+// The decoders are opened on the first Read, so an empty body reads as empty.
+// Reading fails with *DecodedSizeLimitError beyond maxSize decoded bytes if maxSize > 0.
+// Closing the reader closes body if it is an io.Closer.
+func NewContentDecodingReader(body io.Reader, contentEncoding string, maxSize int64) (io.ReadCloser, error) {
+	tokens := ParseContentEncoding(contentEncoding)
+	codecs := make([]*Codec, len(tokens))
+	for i, token := range tokens {
+		if codecs[i] = CodecByContentEncoding(token); codecs[i] == nil {
+			return nil, ErrUnsupportedContentEncoding.WithField("encoding", token)
+		}
+	}
+	decoder := &contentDecodingReader{reader: body, codecs: codecs, limit: maxSize}
+	if closer, ok := body.(io.Closer); ok {
+		decoder.closers = append(decoder.closers, closer)
+	}
+	return decoder, nil
+}
+
+func newCodecReader(codec *Codec, r io.Reader) (io.ReadCloser, error) {
+	if codec.Name != CodecZlib {
+		return codec.NewReader(r)
+	}
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !isZlibHeader(header) {
+		if deflate := CodecByName(CodecDeflate); deflate != nil {
+			return deflate.NewReader(br)
+		}
+	}
+	return codec.NewReader(br)
+}
+
+type contentDecodingReader struct {
+	reader io.Reader
+	// codecs not opened yet, undone from the last
+	codecs  []*Codec
+	closers []io.Closer
+	err     error
+	limit   int64
+	read    int64
+}
+
+func (r *contentDecodingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.codecs != nil {
+		if r.err = r.open(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	if r.limit > 0 && int64(len(p)) > r.limit-r.read+1 {
+		// read one byte over the limit to detect exceeding it
+		p = p[:r.limit-r.read+1]
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.limit > 0 && r.read > r.limit {
+		n -= int(r.read - r.limit)
+		r.read = r.limit
+		return n, &DecodedSizeLimitError{Limit: r.limit}
+	}
+	return n, err
+}
+
+func (r *contentDecodingReader) open() error {
+	codecs := r.codecs
+	r.codecs = nil
+	for i := len(codecs) - 1; i >= 0; i-- {
+		reader, err := newCodecReader(codecs[i], r.reader)
+		if err != nil {
+			return err
+		}
+		r.reader = reader
+		r.closers = append(r.closers, reader)
+	}
+	return nil
+}
+
+// Close closes the decoders from the outermost, then the source body.
+func (r *contentDecodingReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		err = AppendError(err, r.closers[i].Close())
+	}
+	return err
+}
+
+// DecodeResponseBody replaces resp.Body by its decoded stream, see NewContentDecodingReader.
+// Content-Encoding and Content-Length are removed as they don't describe the new body.
+// Nothing is changed if resp isn't encoded or has no body, as responses to HEAD requests,
+// 1xx, 204 and 304 responses, whose Content-Encoding describes the representation.
+func DecodeResponseBody(resp *http.Response, maxSize int64) error {
+	contentEncoding := strings.Join(resp.Header.Values(HTTPHeaderContentEncoding), ",")
+	if len(ParseContentEncoding(contentEncoding)) == 0 || isBodylessResponse(resp) {
+		return nil
+	}
+	reader, err := NewContentDecodingReader(resp.Body, contentEncoding, maxSize)
+	if err != nil {
+		return err
+	}
+	resp.Body = reader
+	resp.Header.Del(HTTPHeaderContentEncoding)
+	resp.Header.Del(HTTPHeaderContentLength)
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// isBodylessResponse reports whether resp has no body to decode.
+func isBodylessResponse(resp *http.Response) bool {
+	return resp.Body == nil || resp.Body == http.NoBody || resp.ContentLength == 0 ||
+		(resp.Request != nil && resp.Request.Method == http.MethodHead) ||
+		(resp.StatusCode >= 100 && resp.StatusCode < 200) ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified
+}
@@ -0,0 +1,119 @@
+package utility
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeWith(t *testing.T, plain []byte, names ...string) []byte {
+	for _, name := range names {
+		var err error
+		plain, err = CodecByName(name).Encode(plain, flate.BestSpeed)
+		assert.Nil(t, err)
+	}
+	return plain
+}
+
+func TestContentDecodingReader(t *testing.T) {
+	plain := []byte(strings.Repeat("content encoding ", 100))
+	cases := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", plain},
+		{"identity", plain},
+		{"GZIP", encodeWith(t, plain, CodecGZip)},
+		{"x-gzip", encodeWith(t, plain, CodecGZip)},
+		{"deflate", encodeWith(t, plain, CodecZlib)},
+		{"deflate", encodeWith(t, plain, CodecDeflate)},
+		{"deflate, gzip", encodeWith(t, plain, CodecZlib, CodecGZip)},
+		{"gzip,identity, gzip", encodeWith(t, plain, CodecGZip, CodecGZip)},
+	}
+	for _, c := range cases {
+		reader, err := NewContentDecodingReader(bytes.NewReader(c.body), c.encoding, 0)
+		assert.Nil(t, err, c.encoding)
+		decoded, err := io.ReadAll(reader)
+		assert.Nil(t, err, c.encoding)
+		assert.Equal(t, plain, decoded, c.encoding)
+		assert.Nil(t, reader.Close())
+	}
+
+	// empty bodies decode as empty, broken ones fail on read
+	reader, err := NewContentDecodingReader(bytes.NewReader(nil), "deflate, gzip", 0)
+	assert.Nil(t, err)
+	decoded, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Empty(t, decoded)
+	reader, _ = NewContentDecodingReader(strings.NewReader("plain"), "gzip", 0)
+	_, err = io.ReadAll(reader)
+	assert.NotNil(t, err)
+
+	_, err = NewContentDecodingReader(bytes.NewReader(plain), "gzip, br", 0)
+	assert.True(t, errors.Is(err, ErrUnsupportedContentEncoding))
+	assert.Equal(t, "br", ErrorFields(err)["encoding"])
+
+	reader, err = NewContentDecodingReader(bytes.NewReader(encodeWith(t, plain, CodecGZip)), "gzip", 100)
+	assert.Nil(t, err)
+	decoded, err = io.ReadAll(reader)
+	var limitErr *DecodedSizeLimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, 100, len(decoded))
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	plain := []byte("hello response")
+	encoded := encodeWith(t, plain, CodecDeflate, CodecGZip)
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Add(HTTPHeaderContentEncoding, "deflate")
+	resp.Header.Add(HTTPHeaderContentEncoding, "gzip")
+	SetBodyToResponse(resp, encoded)
+
+	body, err := ReadDecodedResponseBody(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, body)
+	assert.Equal(t, "", resp.Header.Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, "14", resp.Header.Get(HTTPHeaderContentLength))
+	again, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, plain, again)
+
+	resp = &http.Response{Header: http.Header{HTTPHeaderContentEncoding: {"br"}}}
+	SetBodyToResponse(resp, plain)
+	body, err = ReadDecodedResponseBody(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, body)
+	assert.Equal(t, "br", resp.Header.Get(HTTPHeaderContentEncoding))
+}
+
+func TestDecodeBodylessResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+		if req.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set(HTTPHeaderContentLength, "30")
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodHead, server.URL, nil),
+		httptest.NewRequest(http.MethodGet, server.URL+"/empty", nil),
+	} {
+		req.RequestURI = ""
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		body, err := ReadDecodedResponseBody(resp)
+		assert.Nil(t, err, req.Method)
+		assert.Empty(t, body, req.Method)
+		assert.Equal(t, "gzip", resp.Header.Get(HTTPHeaderContentEncoding), req.Method)
+	}
+}
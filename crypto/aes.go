@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"strconv"
@@ -11,6 +14,8 @@ import (
 
 var (
 	ErrCipherTextLengthIncorrect = errors.New("Cipher text is not a multiple of the block size ")
+	ErrCipherTextTooShort        = errors.New("cipher text is too short")
+	ErrKeyUnwrapFailed           = errors.New("key unwrap failed")
 )
 
 type Coder interface {
@@ -134,3 +139,131 @@ func (coder aesCBCCoder) Decrypt(src []byte) ([]byte, error) {
 	// 解填充
 	return pkcs7strip(encryptData)
 }
+
+// AESGCMCoder is an authenticated Coder, Encrypt prefixes the cipher text with a random nonce.
+// Seal and Open keep the nonce and tag apart, as formats such as JWE do.
+type AESGCMCoder interface {
+	Coder
+	NonceSize() int
+	Seal(nonce, plainText, additionalData []byte) (cipherText, tag []byte, err error)
+	Open(nonce, cipherText, tag, additionalData []byte) ([]byte, error)
+}
+
+// NewAESCoderWithGCM returns an AES-GCM coder, of AES-128, 192 or 256 by the size of key.
+func NewAESCoderWithGCM(key []byte) (AESGCMCoder, error) {
+	c, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	aead, e := cipher.NewGCM(c)
+	if e != nil {
+		return nil, e
+	}
+	return aesGCMCoder{
+		aead: aead,
+	}, nil
+}
+
+type aesGCMCoder struct {
+	aead cipher.AEAD
+}
+
+func (coder aesGCMCoder) NonceSize() int {
+	return coder.aead.NonceSize()
+}
+
+func (coder aesGCMCoder) Encrypt(src []byte) ([]byte, error) {
+	nonce := make([]byte, coder.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return coder.aead.Seal(nonce, nonce, src, nil), nil
+}
+
+func (coder aesGCMCoder) Decrypt(src []byte) ([]byte, error) {
+	nonceSize := coder.aead.NonceSize()
+	if len(src) < nonceSize+coder.aead.Overhead() {
+		return nil, ErrCipherTextTooShort
+	}
+	return coder.aead.Open(nil, src[:nonceSize], src[nonceSize:], nil)
+}
+
+func (coder aesGCMCoder) Seal(nonce, plainText, additionalData []byte) ([]byte, []byte, error) {
+	if len(nonce) != coder.aead.NonceSize() {
+		return nil, nil, errors.New("The length of nonce should be " + strconv.Itoa(coder.aead.NonceSize()))
+	}
+	sealed := coder.aead.Seal(nil, nonce, plainText, additionalData)
+	tagStart := len(sealed) - coder.aead.Overhead()
+	return sealed[:tagStart], sealed[tagStart:], nil
+}
+
+func (coder aesGCMCoder) Open(nonce, cipherText, tag, additionalData []byte) ([]byte, error) {
+	if len(nonce) != coder.aead.NonceSize() {
+		return nil, errors.New("The length of nonce should be " + strconv.Itoa(coder.aead.NonceSize()))
+	}
+	if len(tag) != coder.aead.Overhead() {
+		return nil, ErrCipherTextTooShort
+	}
+	sealed := make([]byte, 0, len(cipherText)+len(tag))
+	sealed = append(append(sealed, cipherText...), tag...)
+	return coder.aead.Open(nil, nonce, sealed, additionalData)
+}
+
+// aesKeyWrapIV is the default initial value of RFC 3394 2.2.3.1.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// AESKeyWrap wraps key, a multiple of 8 bytes of 16 or more, with kek (RFC 3394).
+func AESKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("The length of key should be a multiple of 8, of 16 or more")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	wrapped := make([]byte, 8+len(key))
+	copy(wrapped, aesKeyWrapIV)
+	copy(wrapped[8:], key)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, wrapped[:8])
+			copy(buf[8:], wrapped[i*8:])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(wrapped[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(wrapped[i*8:], buf[8:])
+		}
+	}
+	return wrapped, nil
+}
+
+// AESKeyUnwrap unwraps a key wrapped by AESKeyWrap, ErrKeyUnwrapFailed if the integrity check fails.
+func AESKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	key := make([]byte, len(wrapped))
+	copy(key, wrapped)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(key[:8])^t)
+			copy(buf[8:], key[i*8:])
+			block.Decrypt(buf, buf)
+			copy(key[:8], buf[:8])
+			copy(key[i*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(key[:8], aesKeyWrapIV) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return key[8:], nil
+}
@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"encoding/hex"
+	"fmt"
 	"testing"
 )
 
@@ -48,3 +50,73 @@ func TestNewAESCoderWithCBC(t *testing.T) {
 		t.Log("success")
 	}
 }
+
+func TestNewAESCoderWithGCM(t *testing.T) {
+	key := []byte("1234567890abcdef1234567890abcdef")
+	plain := []byte("Hello")
+
+	gcm, err := NewAESCoderWithGCM(key)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	encryptBytes, err := gcm.Encrypt(plain)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	decryptBytes, err := gcm.Decrypt(encryptBytes)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	if string(decryptBytes) != string(plain) {
+		t.Fatal("the text decrypted does not match the text before encrypted")
+	}
+
+	encryptBytes[len(encryptBytes)-1] ^= 1
+	if _, err := gcm.Decrypt(encryptBytes); err == nil {
+		t.Fatal("tampered cipher text decrypted")
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	cipherText, tag, err := gcm.Seal(nonce, plain, []byte("header"))
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	if _, err := gcm.Open(nonce, cipherText, tag, []byte("other")); err == nil {
+		t.Fatal("cipher text opened with other additional data")
+	}
+	decryptBytes, err = gcm.Open(nonce, cipherText, tag, []byte("header"))
+	if err != nil || string(decryptBytes) != string(plain) {
+		t.Fatal("fail", err)
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// RFC 3394 4.6
+	kek := mustDecodeHex(t, "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key := mustDecodeHex(t, "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected := "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"
+
+	wrapped, err := AESKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	if fmt.Sprintf("%X", wrapped) != expected {
+		t.Fatalf("wrapped key %X, expected %s", wrapped, expected)
+	}
+	unwrapped, err := AESKeyUnwrap(kek, wrapped)
+	if err != nil || fmt.Sprintf("%X", unwrapped) != fmt.Sprintf("%X", key) {
+		t.Fatal("fail", err)
+	}
+	wrapped[0] ^= 1
+	if _, err := AESKeyUnwrap(kek, wrapped); err != ErrKeyUnwrapFailed {
+		t.Fatal("tampered key unwrapped", err)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -7,6 +7,13 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+)
+
+var (
+	ErrInvalidPEM    = errors.New("no PEM block found")
+	ErrNotRSAKey     = errors.New("not an RSA key")
+	ErrInvalidRSAKey = errors.New("invalid RSA key")
 )
 
 type RSAEncoder interface {
@@ -19,6 +26,20 @@ type RSADecoder interface {
 	Sign(msg []byte) ([]byte, error)
 }
 
+// RSAOAEPEncoder is an RSAEncoder that also encrypts with RSA-OAEP and SHA-256,
+// as the encoders of NewRSAEncoder do.
+type RSAOAEPEncoder interface {
+	RSAEncoder
+	EncryptOAEP([]byte) ([]byte, error)
+}
+
+// RSAOAEPDecoder is an RSADecoder that also decrypts RSA-OAEP with SHA-256,
+// as the decoders of NewRSADecoder do.
+type RSAOAEPDecoder interface {
+	RSADecoder
+	DecryptOAEP([]byte) ([]byte, error)
+}
+
 func NewRSAKeys(bits int) (publicKey []byte, privateKey []byte, err error) {
 	newKey, err := rsa.GenerateKey(rand.Reader, bits)
 
@@ -46,81 +67,132 @@ func NewRSAKeys(bits int) (publicKey []byte, privateKey []byte, err error) {
 	return
 }
 
+// ParseRSAPublicKey reads a PKIX or PKCS #1 public key in PEM, such as the ones of NewRSAKeys.
+func ParseRSAPublicKey(pubKey []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pubKey)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+	if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return publicKey, nil
+	}
+	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrNotRSAKey
+	}
+	return publicKey, nil
+}
+
+// ParseRSAPrivateKey reads a PKCS #1 or PKCS #8 private key in PEM, such as the ones of NewRSAKeys.
+func ParseRSAPrivateKey(privKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privKey)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+	privateKeyInterface, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := privateKeyInterface.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotRSAKey
+	}
+	return privateKey, nil
+}
+
 func NewRSAEncoder(pubKey []byte) RSAEncoder {
+	publicKey, err := ParseRSAPublicKey(pubKey)
+	return rsaEncoder{
+		publicKey: publicKey,
+		err:       err,
+	}
+}
+
+func NewRSAEncoderWithKey(publicKey *rsa.PublicKey) RSAOAEPEncoder {
+	if publicKey == nil {
+		return rsaEncoder{err: ErrInvalidRSAKey}
+	}
 	return rsaEncoder{
-		publicKey: pubKey,
+		publicKey: publicKey,
 	}
 }
 
 type rsaEncoder struct {
-	publicKey []byte
+	publicKey *rsa.PublicKey
+	// err is the error of parsing the key, returned by every operation
+	err error
 }
 
 func (encoder rsaEncoder) Encrypt(src []byte) ([]byte, error) {
-	block, _ := pem.Decode([]byte(encoder.publicKey))
-
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	if encoder.err != nil {
+		return nil, encoder.err
 	}
-	publicKey := publicKeyInterface.(*rsa.PublicKey)
+	return rsa.EncryptPKCS1v15(rand.Reader, encoder.publicKey, src)
+}
 
-	cipherText, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, src)
-	if err != nil {
-		return nil, err
+func (encoder rsaEncoder) EncryptOAEP(src []byte) ([]byte, error) {
+	if encoder.err != nil {
+		return nil, encoder.err
 	}
-
-	return cipherText, nil
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, encoder.publicKey, src, nil)
 }
 
 func (encoder rsaEncoder) VerifySign(msg, sign []byte) bool {
-	block, _ := pem.Decode([]byte(encoder.publicKey))
-
-	publicInterface, _ := x509.ParsePKIXPublicKey(block.Bytes)
-	publicKey := publicInterface.(*rsa.PublicKey)
-	myHash := sha256.New()
-	myHash.Write([]byte(msg))
-	hashed := myHash.Sum(nil)
-	result := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed, sign)
+	if encoder.err != nil {
+		return false
+	}
+	hashed := sha256.Sum256(msg)
+	result := rsa.VerifyPKCS1v15(encoder.publicKey, crypto.SHA256, hashed[:], sign)
 	return result == nil
 }
 
 func NewRSADecoder(privKey []byte) RSADecoder {
+	privateKey, err := ParseRSAPrivateKey(privKey)
 	return rsaDecoder{
-		privateKey: privKey,
+		privateKey: privateKey,
+		err:        err,
+	}
+}
+
+func NewRSADecoderWithKey(privateKey *rsa.PrivateKey) RSAOAEPDecoder {
+	if privateKey == nil {
+		return rsaDecoder{err: ErrInvalidRSAKey}
+	}
+	return rsaDecoder{
+		privateKey: privateKey,
 	}
 }
 
 type rsaDecoder struct {
-	privateKey []byte
+	privateKey *rsa.PrivateKey
+	err        error
 }
 
 func (decoder rsaDecoder) Decrypt(src []byte) ([]byte, error) {
-	block, _ := pem.Decode([]byte(decoder.privateKey))
-
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-	plainText, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, src)
-	if err != nil {
-		return nil, err
+	if decoder.err != nil {
+		return nil, decoder.err
 	}
+	return rsa.DecryptPKCS1v15(rand.Reader, decoder.privateKey, src)
+}
 
-	return plainText, nil
+func (decoder rsaDecoder) DecryptOAEP(src []byte) ([]byte, error) {
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, decoder.privateKey, src, nil)
 }
 
 func (decoder rsaDecoder) Sign(msg []byte) ([]byte, error) {
-	block, _ := pem.Decode([]byte(decoder.privateKey))
-
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	myHash := sha256.New()
-	myHash.Write([]byte(msg))
-	hashed := myHash.Sum(nil)
-	sign, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed)
-	if err != nil {
-		return nil, err
+	if decoder.err != nil {
+		return nil, decoder.err
 	}
-
-	return sign, nil
+	hashed := sha256.Sum256(msg)
+	return rsa.SignPKCS1v15(rand.Reader, decoder.privateKey, crypto.SHA256, hashed[:])
 }
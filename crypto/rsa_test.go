@@ -37,3 +37,38 @@ func TestRsa(t *testing.T) {
 
 	t.Log("success")
 }
+
+func TestRsaOAEP(t *testing.T) {
+	plain := []byte("Hello")
+	pbKey, pvKey, err := NewRSAKeys(2048)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	publicKey, err := ParseRSAPublicKey(pbKey)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	privateKey, err := ParseRSAPrivateKey(pvKey)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+
+	encryptData, err := NewRSAEncoderWithKey(publicKey).EncryptOAEP(plain)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	decryptData, err := NewRSADecoder(pvKey).(RSAOAEPDecoder).DecryptOAEP(encryptData)
+	if err != nil {
+		t.Fatal("fail", err)
+	}
+	if string(decryptData) != string(plain) {
+		t.Fatal("the text decrypted dose not match the text before encrypted")
+	}
+	if _, err := NewRSADecoderWithKey(privateKey).Decrypt(encryptData); err == nil {
+		t.Fatal("OAEP cipher text decrypted as PKCS #1 v1.5")
+	}
+
+	if _, err := NewRSAEncoder([]byte("not a key")).Encrypt(plain); err != ErrInvalidPEM {
+		t.Fatal("invalid key accepted", err)
+	}
+}
@@ -0,0 +1,330 @@
+package utility
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+type ErrorCode string
+
+type ErrorCategory string
+
+const (
+	ErrorCategoryUnknown         ErrorCategory = ""
+	ErrorCategoryInvalidArgument ErrorCategory = "invalid_argument"
+	ErrorCategoryNotFound        ErrorCategory = "not_found"
+	ErrorCategoryConflict        ErrorCategory = "conflict"
+	ErrorCategoryUnauthorized    ErrorCategory = "unauthorized"
+	ErrorCategoryForbidden       ErrorCategory = "forbidden"
+	ErrorCategoryTimeout         ErrorCategory = "timeout"
+	ErrorCategoryUnavailable     ErrorCategory = "unavailable"
+	ErrorCategoryInternal        ErrorCategory = "internal"
+	ErrorCategoryPanic           ErrorCategory = "panic"
+)
+
+const errorStackDepth = 32
+
+// Error is an error carrying the stack trace of where it was created,
+// an optional cause, key/value context fields, a code and a category.
+//
+// Error values are immutable, the With* methods return modified copies,
+// so package level sentinels can be decorated safely.
+// Use %+v to print the message together with the stack trace.
+type Error struct {
+	message  string
+	cause    error
+	code     ErrorCode
+	category ErrorCategory
+	fields   StrMap
+	stack    []uintptr
+}
+
+// NewError returns an *Error with message formatted by fmt.Sprintf, recording the caller's stack.
+func NewError(format string, args ...interface{}) *Error {
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+	return &Error{message: msg, stack: callers(3)}
+}
+
+// WrapError annotates err with message and key/value pairs (e.g. "user", 12, "path", p),
+// recording the caller's stack. Returns nil if err is nil.
+func WrapError(err error, message string, keyValues ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		message: message,
+		cause:   err,
+		fields:  AnyArrayToStrMap(keyValues),
+		stack:   callers(3),
+	}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.cause == nil:
+		return e.message
+	case e.message == "":
+		return e.cause.Error()
+	default:
+		return e.message + ": " + e.cause.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an *Error with the same non-empty code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e == t || (t.code != "" && e.code == t.code)
+}
+
+func (e *Error) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			_, _ = io.WriteString(s, e.Error())
+			if fields := ErrorFields(e); len(fields) > 0 {
+				_, _ = io.WriteString(s, " "+formatErrorFields(fields))
+			}
+			_, _ = io.WriteString(s, "\n"+e.StackTrace())
+			return
+		}
+		_, _ = io.WriteString(s, e.Error())
+	case 's':
+		_, _ = io.WriteString(s, e.Error())
+	case 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+func (e *Error) WithCode(code ErrorCode) *Error {
+	c := *e
+	c.code = code
+	return &c
+}
+
+func (e *Error) WithCategory(category ErrorCategory) *Error {
+	c := *e
+	c.category = category
+	return &c
+}
+
+func (e *Error) WithField(key string, value interface{}) *Error {
+	return e.WithFields(StrMap{key: value})
+}
+
+func (e *Error) WithFields(fields StrMap) *Error {
+	c := *e
+	c.fields = make(StrMap, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		c.fields[k] = v
+	}
+	for k, v := range fields {
+		c.fields[k] = v
+	}
+	return &c
+}
+
+// Code returns the code of e, or the first code found in its cause chain.
+func (e *Error) Code() ErrorCode {
+	return ErrorCodeOf(e)
+}
+
+// Category returns the category of e, or the first category found in its cause chain.
+func (e *Error) Category() ErrorCategory {
+	return ErrorCategoryOf(e)
+}
+
+// Fields returns the fields of e merged with those of its cause chain, outer fields win.
+func (e *Error) Fields() StrMap {
+	return ErrorFields(e)
+}
+
+// StackTrace returns the stack recorded when e was created, one "function\n\tfile:line" per frame.
+func (e *Error) StackTrace() string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			sb.WriteString(fmt.Sprintf("%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+func ErrorCodeOf(err error) ErrorCode {
+	for err != nil {
+		if e, ok := err.(*Error); ok && e.code != "" {
+			return e.code
+		}
+		err = errors.Unwrap(err)
+	}
+	return ""
+}
+
+func ErrorCategoryOf(err error) ErrorCategory {
+	for err != nil {
+		if e, ok := err.(*Error); ok && e.category != ErrorCategoryUnknown {
+			return e.category
+		}
+		err = errors.Unwrap(err)
+	}
+	return ErrorCategoryUnknown
+}
+
+// ErrorFields collects the fields of every *Error in the chain of err, outer fields win.
+func ErrorFields(err error) StrMap {
+	var fields StrMap
+	for err != nil {
+		if e, ok := err.(*Error); ok && len(e.fields) > 0 {
+			if fields == nil {
+				fields = make(StrMap, len(e.fields))
+			}
+			for k, v := range e.fields {
+				if _, exists := fields[k]; !exists {
+					fields[k] = v
+				}
+			}
+		}
+		err = errors.Unwrap(err)
+	}
+	return fields
+}
+
+func formatErrorFields(fields StrMap) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + AnyToString(fields[k])
+	}
+	return "[" + strings.Join(pairs, " ") + "]"
+}
+
+func callers(skip int) []uintptr {
+	var pcs [errorStackDepth]uintptr
+	n := runtime.Callers(skip, pcs[:])
+	return pcs[:n]
+}
+
+// MultiError aggregates several errors, errors.Is and errors.As match any of them.
+type MultiError struct {
+	errs []error
+}
+
+// AppendError appends errs to err, flattening nested *MultiError and skipping nil.
+// Returns nil if nothing is left.
+func AppendError(err error, errs ...error) error {
+	m := &MultiError{}
+	m.Append(err)
+	for _, e := range errs {
+		m.Append(e)
+	}
+	return m.ErrorOrNil()
+}
+
+func (m *MultiError) Append(err error) {
+	if err == nil {
+		return
+	}
+	if other, ok := err.(*MultiError); ok {
+		m.errs = append(m.errs, other.errs...)
+		return
+	}
+	m.errs = append(m.errs, err)
+}
+
+func (m *MultiError) Errors() []error {
+	return m.errs
+}
+
+// ErrorOrNil returns nil for an empty MultiError, the only error if there is just one, or m.
+func (m *MultiError) ErrorOrNil() error {
+	switch len(m.errs) {
+	case 0:
+		return nil
+	case 1:
+		return m.errs[0]
+	default:
+		return m
+	}
+}
+
+func (m *MultiError) Error() string {
+	msgs := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(m.errs), strings.Join(msgs, "; "))
+}
+
+func (m *MultiError) Unwrap() []error {
+	return m.errs
+}
+
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recover calls fn and converts a panic into an *Error of ErrorCategoryPanic
+// whose stack trace points at the panic site.
+func Recover(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func panicError(r interface{}) *Error {
+	if e, ok := r.(*Error); ok {
+		if e.Category() == ErrorCategoryUnknown {
+			return e.WithCategory(ErrorCategoryPanic)
+		}
+		return e
+	}
+	// skip runtime.Callers, callers, panicError, the deferred func and runtime.gopanic
+	e := &Error{category: ErrorCategoryPanic, stack: callers(5)}
+	if cause, ok := r.(error); ok {
+		e.message = "panic"
+		e.cause = cause
+	} else {
+		e.message = "panic: " + AnyToString(r)
+	}
+	return e
+}
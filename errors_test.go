@@ -0,0 +1,79 @@
+package utility
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestNotFound = NewError("record not found").WithCode("E404").WithCategory(ErrorCategoryNotFound)
+
+func TestErrorWrap(t *testing.T) {
+	err := WrapError(io.EOF, "read config", "path", "/etc/app.yaml")
+	err = WrapError(err, "load", "path", "outer", "retry", 2)
+	assert.Equal(t, "load: read config: EOF", err.Error())
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.Equal(t, StrMap{"path": "outer", "retry": 2}, ErrorFields(err))
+	assert.Nil(t, WrapError(nil, "nothing"))
+
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Contains(t, e.StackTrace(), "TestErrorWrap")
+	verbose := fmt.Sprintf("%+v", err)
+	assert.True(t, strings.HasPrefix(verbose, "load: read config: EOF [path=outer retry=2]\n"))
+}
+
+func TestErrorCode(t *testing.T) {
+	err := WrapError(errTestNotFound.WithField("id", 7), "query user")
+	assert.True(t, errors.Is(err, errTestNotFound))
+	assert.Equal(t, ErrorCode("E404"), ErrorCodeOf(err))
+	assert.Equal(t, ErrorCategoryNotFound, ErrorCategoryOf(err))
+	assert.Equal(t, 7, ErrorFields(err)["id"])
+	assert.False(t, errors.Is(NewError("other"), errTestNotFound))
+}
+
+func TestMultiError(t *testing.T) {
+	assert.Nil(t, AppendError(nil, nil))
+	assert.Equal(t, io.EOF, AppendError(nil, io.EOF))
+
+	err := AppendError(io.EOF, errTestNotFound, nil)
+	err = AppendError(err, io.ErrUnexpectedEOF)
+	var m *MultiError
+	assert.True(t, errors.As(err, &m))
+	assert.Equal(t, 3, len(m.Errors()))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.True(t, errors.Is(err, errTestNotFound))
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, ErrorCode("E404"), e.Code())
+	assert.Equal(t, "3 errors occurred: EOF; record not found; unexpected EOF", err.Error())
+}
+
+func TestRecover(t *testing.T) {
+	assert.Nil(t, Recover(func() {}))
+
+	err := Recover(func() {
+		panic("boom")
+	})
+	assert.Equal(t, "panic: boom", err.Error())
+	assert.Equal(t, ErrorCategoryPanic, ErrorCategoryOf(err))
+	assert.Contains(t, err.(*Error).StackTrace(), "TestRecover")
+
+	err = Recover(func() {
+		PanicIfNotNil(io.EOF)
+	})
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.Equal(t, ErrorCategoryPanic, ErrorCategoryOf(err))
+	assert.Contains(t, err.(*Error).StackTrace(), "TestRecover")
+
+	func() {
+		defer func() {
+			assert.Equal(t, io.EOF, recover())
+		}()
+		PanicIfNotNil(io.EOF)
+	}()
+}
@@ -1,8 +1,11 @@
 package utility
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"fmt"
+	"io"
 	"io/ioutil"
 )
 
@@ -30,3 +33,175 @@ func GZipDecompress(in []byte) ([]byte, error) {
 	defer reader.Close()
 	return ioutil.ReadAll(reader)
 }
+
+// GZipCompressPooled is GZipCompress with a pooled gzip.Writer and buffer.
+func GZipCompressPooled(in []byte) ([]byte, error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	writer := DefaultGZipWriterPool.Get(buf)
+	defer DefaultGZipWriterPool.Put(writer)
+	_, err := writer.Write(in)
+	if err == nil {
+		err = writer.Close()
+	} else {
+		_ = writer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return copyBufferBytes(buf), nil
+}
+
+// GZipDecompressPooled is GZipDecompress with a pooled gzip.Reader and buffer.
+func GZipDecompressPooled(in []byte) ([]byte, error) {
+	reader, err := GetGZipReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	defer PutGZipReader(reader)
+	return readBytesCopy(reader)
+}
+
+// GZipSizeLimitError is returned when decompressed data exceeds the configured limit,
+// which defends against gzip bombs.
+type GZipSizeLimitError struct {
+	Limit int64
+}
+
+func (e *GZipSizeLimitError) Error() string {
+	return fmt.Sprintf("gzip: decompressed size exceeds limit of %d bytes", e.Limit)
+}
+
+// NewGZipWriter returns a streaming gzip writer with compression level (gzip.DefaultCompression etc.),
+// writing header fields such as Name, Comment and ModTime if header is not nil.
+// The caller must Close it to flush the gzip footer.
+func NewGZipWriter(w io.Writer, level int, header *gzip.Header) (*gzip.Writer, error) {
+	writer, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		writer.Header = *header
+	}
+	return writer, nil
+}
+
+type GZipReaderOptions struct {
+	// MaxDecompressedSize fails reads with *GZipSizeLimitError beyond it, 0 means no limit.
+	MaxDecompressedSize int64
+	// SingleMember stops at the end of the first gzip member instead of reading concatenated members.
+	SingleMember bool
+}
+
+// GZipReader decompresses a gzip stream of one or more concatenated members,
+// keeping the header of every member read.
+type GZipReader struct {
+	src     *bufio.Reader
+	reader  *gzip.Reader
+	opts    GZipReaderOptions
+	read    int64
+	headers []gzip.Header
+	err     error
+}
+
+// NewGZipReader reads the header of the first member of r and returns a reader of its decompressed content.
+func NewGZipReader(r io.Reader, opts GZipReaderOptions) (*GZipReader, error) {
+	src, ok := r.(*bufio.Reader)
+	if !ok {
+		src = bufio.NewReader(r)
+	}
+	reader, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	reader.Multistream(false)
+	return &GZipReader{src: src, reader: reader, opts: opts, headers: []gzip.Header{reader.Header}}, nil
+}
+
+// Header returns the header of the member being read.
+func (r *GZipReader) Header() gzip.Header {
+	return r.headers[len(r.headers)-1]
+}
+
+// Headers returns the headers of all members read so far.
+func (r *GZipReader) Headers() []gzip.Header {
+	return r.headers
+}
+
+func (r *GZipReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	limit := r.opts.MaxDecompressedSize
+	if limit > 0 && int64(len(p)) > limit-r.read+1 {
+		// read one byte over the limit to detect exceeding it
+		p = p[:limit-r.read+1]
+	}
+	for {
+		n, err := r.reader.Read(p)
+		r.read += int64(n)
+		if limit > 0 && r.read > limit {
+			n -= int(r.read - limit)
+			r.read = limit
+			r.err = &GZipSizeLimitError{Limit: limit}
+			return n, r.err
+		}
+		if err == io.EOF && !r.opts.SingleMember {
+			err = r.nextMember()
+			if err == nil && n == 0 {
+				// an empty member, read the next one
+				continue
+			}
+		}
+		if err != nil {
+			r.err = err
+		}
+		return n, err
+	}
+}
+
+func (r *GZipReader) nextMember() error {
+	if err := r.reader.Reset(r.src); err != nil {
+		return err
+	}
+	r.reader.Multistream(false)
+	r.headers = append(r.headers, r.reader.Header)
+	return nil
+}
+
+func (r *GZipReader) Close() error {
+	return r.reader.Close()
+}
+
+// GZipCompressStream compresses src into dst with level, returning the number of uncompressed bytes.
+func GZipCompressStream(dst io.Writer, src io.Reader, level int) (int64, error) {
+	writer, err := NewGZipWriter(dst, level, nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(writer, src)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// GZipDecompressStream decompresses all members of src into dst, failing with *GZipSizeLimitError
+// beyond maxSize decompressed bytes if maxSize > 0.
+func GZipDecompressStream(dst io.Writer, src io.Reader, maxSize int64) (int64, error) {
+	reader, err := NewGZipReader(src, GZipReaderOptions{MaxDecompressedSize: maxSize})
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.Copy(dst, reader)
+}
+
+// GZipDecompressWithLimit is GZipDecompress failing with *GZipSizeLimitError beyond maxSize decompressed bytes.
+func GZipDecompressWithLimit(in []byte, maxSize int64) ([]byte, error) {
+	var buffer bytes.Buffer
+	if _, err := GZipDecompressStream(&buffer, bytes.NewReader(in), maxSize); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package utility
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGZipStreamHeaders(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	for i, name := range []string{"a.txt", "b.txt"} {
+		writer, err := NewGZipWriter(&buf, gzip.BestCompression, &gzip.Header{Name: name, Comment: "member", ModTime: modTime})
+		assert.Nil(t, err)
+		_, _ = writer.Write([]byte(strings.Repeat(name, i+1)))
+		assert.Nil(t, writer.Close())
+	}
+
+	reader, err := NewGZipReader(&buf, GZipReaderOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "a.txt", reader.Header().Name)
+	plain, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "a.txtb.txtb.txt", string(plain))
+	headers := reader.Headers()
+	assert.Equal(t, 2, len(headers))
+	assert.Equal(t, "b.txt", headers[1].Name)
+	assert.Equal(t, "member", headers[1].Comment)
+	assert.True(t, modTime.Equal(headers[1].ModTime))
+
+	_, err = NewGZipWriter(&buf, 42, nil)
+	assert.NotNil(t, err)
+}
+
+func TestGZipSingleMember(t *testing.T) {
+	first, _ := GZipCompress([]byte("first"))
+	second, _ := GZipCompress([]byte("second"))
+	reader, err := NewGZipReader(bytes.NewReader(append(first, second...)), GZipReaderOptions{SingleMember: true})
+	assert.Nil(t, err)
+	plain, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(plain))
+}
+
+func TestGZipEmptyMembers(t *testing.T) {
+	empty, _ := GZipCompress(nil)
+	last, _ := GZipCompress([]byte("data"))
+	stream := append(bytes.Repeat(empty, 10000), last...)
+	reader, err := NewGZipReader(bytes.NewReader(stream), GZipReaderOptions{})
+	assert.Nil(t, err)
+	p := make([]byte, 16)
+	n, err := reader.Read(p)
+	assert.True(t, err == nil || err == io.EOF, err)
+	assert.Equal(t, "data", string(p[:n]))
+	assert.Equal(t, 10001, len(reader.Headers()))
+}
+
+func TestGZipSizeLimit(t *testing.T) {
+	var compressed bytes.Buffer
+	n, err := GZipCompressStream(&compressed, strings.NewReader(strings.Repeat("0", 1<<20)), gzip.BestSpeed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1<<20), n)
+
+	plain, err := GZipDecompressWithLimit(compressed.Bytes(), 1<<20)
+	assert.Nil(t, err)
+	assert.Equal(t, 1<<20, len(plain))
+
+	var out bytes.Buffer
+	n, err = GZipDecompressStream(&out, bytes.NewReader(compressed.Bytes()), 1000)
+	var limitErr *GZipSizeLimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, int64(1000), limitErr.Limit)
+	assert.Equal(t, int64(1000), n)
+}
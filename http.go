@@ -2,13 +2,10 @@ package utility
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
 	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"strings"
 )
 
 const (
@@ -23,34 +20,70 @@ const (
 	ContentTypeFormData = "multipart/form-data"
 )
 
+// ReadDecodedResponseBody reads the body of resp decoded from its Content-Encoding.
+// resp.Body is then set to the decoded content, with Content-Encoding removed and
+// Content-Length updated, so resp can be passed on.
 func ReadDecodedResponseBody(resp *http.Response) ([]byte, error) {
+	// an unsupported encoding leaves the body as is
+	if err := DecodeResponseBody(resp, 0); err != nil && !errors.Is(err, ErrUnsupportedContentEncoding) {
+		return nil, err
+	}
 	body, err := ReadAllFromReadCloser(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	return ReadDecodedBodyWithHeader(body, resp.Header.Get(HTTPHeaderContentEncoding))
+	_ = resp.Body.Close()
+	SetBodyToResponse(resp, body)
+	return body, nil
 }
 
+// ReadDecodedBodyWithHeader decodes body from contentEncoding, see NewContentDecodingReader.
+// body is returned as is if an encoding is not supported.
 func ReadDecodedBodyWithHeader(body []byte, contentEncoding string) ([]byte, error) {
-	switch contentEncoding {
-	case "gzip":
-		reader, err := gzip.NewReader(bytes.NewReader(body))
-		if err != nil {
-			return nil, err
-		}
-		defer reader.Close()
-		return ReadBytes(reader)
-	case "deflate":
-		reader := flate.NewReader(bytes.NewReader(body))
-		defer reader.Close()
-		return ReadBytes(reader)
-	default:
+	reader, err := NewContentDecodingReader(bytes.NewReader(body), contentEncoding, 0)
+	if errors.Is(err, ErrUnsupportedContentEncoding) {
+		return body, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ReadBytes(reader)
+}
+
+// ReadDecodedBodyWithHeaderPooled is ReadDecodedBodyWithHeader with pooled decoders and buffers.
+func ReadDecodedBodyWithHeaderPooled(body []byte, contentEncoding string) ([]byte, error) {
+	if tokens := ParseContentEncoding(contentEncoding); len(tokens) == 1 && CodecByContentEncoding(tokens[0]) == CodecByName(CodecGZip) {
+		return GZipDecompressPooled(body)
+	}
+	reader, err := NewContentDecodingReader(bytes.NewReader(body), contentEncoding, 0)
+	if errors.Is(err, ErrUnsupportedContentEncoding) {
 		return body, nil
+	} else if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
+	return readBytesCopy(reader)
 }
 
+// IsRequestFromLocalhost reports whether req was sent from a loopback address of this host.
+// Requests relayed by a local proxy, i.e. with forwarding headers, are not from localhost,
+// see IsClientLocalhost for those.
 func IsRequestFromLocalhost(req *http.Request) bool {
-	return strings.Contains(req.Host, "127.0.0.1") || strings.Contains(req.Host, "localhost")
+	for _, header := range []string{HTTPHeaderForwarded, HTTPHeaderXForwardedFor, HTTPHeaderXRealIP} {
+		if len(req.Header.Values(header)) > 0 {
+			return false
+		}
+	}
+	return IsLoopbackIP(RequestRemoteIP(req))
+}
+
+// RequestRemoteIP returns the IP of the peer that sent req, nil if RemoteAddr can't be parsed.
+func RequestRemoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
 }
 
 func SetBodyToRequest(req *http.Request, body []byte) {
@@ -65,18 +98,15 @@ func SetBodyToResponse(resp *http.Response, body []byte) {
 	resp.Header.Set(HTTPHeaderContentLength, AnyToString(resp.ContentLength))
 }
 
-// GetLocalhostIP 取得本机在局域网的IP
+// GetLanIP 取得本机在局域网的IP
+// It is the first private IPv4 of an interface that is up and not virtual (see DefaultVirtualInterfaces),
+// else the first global one, ErrIPNotFound if there is none.
 func GetLanIP() (net.IP, error) {
-	ips, err := GetNetIPs()
+	ifaces, err := NetInterfaces()
 	if err != nil {
 		return nil, err
 	}
-	for _, ip := range ips {
-		if !ip.IsLoopback() && !strings.Contains(ip.String(), ":") {
-			return ip, nil
-		}
-	}
-	return nil, errors.New("not found")
+	return selectLanIP(ifaces)
 }
 
 func GetNetIPs() (ips []net.IP, err error) {
@@ -0,0 +1,346 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HTTPHeaderAccept         = "Accept"
+	HTTPHeaderAuthorization  = "Authorization"
+	HTTPHeaderIdempotencyKey = "Idempotency-Key"
+)
+
+// RoundTripperFunc is an http.RoundTripper function.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// RoundTripperMiddleware wraps an http.RoundTripper, e.g. for logging, auth or metrics.
+type RoundTripperMiddleware func(next http.RoundTripper) http.RoundTripper
+
+// HTTPStatusError is returned by the JSON helpers of HTTPClient for responses of status >= 400.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body is the decoded response body.
+	Body []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "http status " + e.Status
+}
+
+type HTTPClientOptions struct {
+	// BaseURL is prepended to request paths which are not absolute URLs.
+	BaseURL string
+	// Header is set on every request, unless the request sets it.
+	Header http.Header
+	// Timeout bounds each attempt including reading the response body, 0 means no timeout.
+	// WithHTTPTimeout overrides it for a request.
+	Timeout time.Duration
+	// MaxResponseSize bounds decoded response bodies, reading beyond fails with *DecodedSizeLimitError.
+	MaxResponseSize int64
+	// Retry retries idempotent requests on transport errors and RetryStatusCodes, nil disables retries.
+	// Its AttemptTimeout is ignored in favor of Timeout.
+	Retry *RetryPolicy
+	// RetryStatusCodes default to 429, 502, 503 and 504.
+	RetryStatusCodes []int
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// Middlewares wrap Transport, the first one is the outermost.
+	Middlewares []RoundTripperMiddleware
+}
+
+// HTTPClient is an http.Client with a base URL, default headers, retries with backoff
+// for idempotent requests, per-attempt timeouts, response size limits, transparent
+// response decoding and JSON helpers.
+type HTTPClient struct {
+	opts   HTTPClientOptions
+	client *http.Client
+}
+
+func NewHTTPClient(opts HTTPClientOptions) *HTTPClient {
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(opts.Middlewares) - 1; i >= 0; i-- {
+		transport = opts.Middlewares[i](transport)
+	}
+	if len(opts.RetryStatusCodes) == 0 {
+		opts.RetryStatusCodes = []int{
+			http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		}
+	}
+	return &HTTPClient{opts: opts, client: &http.Client{Transport: transport}}
+}
+
+// HTTPClient returns the underlying http.Client, which has the middlewares but no retries.
+func (c *HTTPClient) HTTPClient() *http.Client {
+	return c.client
+}
+
+type httpTimeoutKey struct{}
+
+// WithHTTPTimeout overrides HTTPClientOptions.Timeout for requests made with ctx.
+func WithHTTPTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, httpTimeoutKey{}, timeout)
+}
+
+// NewRequest creates a request to path resolved against BaseURL.
+func (c *HTTPClient) NewRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, c.resolveURL(path), body)
+}
+
+func (c *HTTPClient) resolveURL(path string) string {
+	if c.opts.BaseURL == "" || strings.Contains(path, "://") {
+		return path
+	}
+	return strings.TrimRight(c.opts.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// Do sends req, retrying it if it is idempotent and a retry policy is set.
+// The response body is decoded from its Content-Encoding and limited to MaxResponseSize,
+// the caller must close it. A response of a retried status is returned once retries are exhausted.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	for key, values := range c.opts.Header {
+		if _, ok := req.Header[key]; !ok {
+			// copied, not to append to the client's header when req.Header is changed
+			req.Header[key] = append([]string(nil), values...)
+		}
+	}
+	if req.Header.Get(HTTPHeaderAcceptEncoding) == "" {
+		req.Header.Set(HTTPHeaderAcceptEncoding, "gzip, deflate")
+	}
+	if c.opts.Retry == nil || !isIdempotentRequest(req) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return c.attempt(req.Context(), req)
+	}
+
+	policy := *c.opts.Retry
+	policy.AttemptTimeout = 0
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+	policy.Retryable = func(err error) bool {
+		var statusErr *HTTPStatusError
+		return errors.As(err, &statusErr) || retryable(err)
+	}
+	var resp *http.Response
+	_, err := Retry(req.Context(), &policy, func(ctx context.Context) error {
+		if resp != nil {
+			// discard the response of the previous attempt
+			_ = resp.Body.Close()
+			resp = nil
+		}
+		attemptReq := req
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return PermanentError(err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+		var err error
+		if resp, err = c.attempt(ctx, attemptReq); err != nil {
+			return err
+		}
+		if c.isRetryStatus(resp.StatusCode) {
+			return &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header}
+		}
+		return nil
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+func (c *HTTPClient) attempt(ctx context.Context, req *http.Request) (*http.Response, error) {
+	timeout := c.opts.Timeout
+	if v, ok := ctx.Value(httpTimeoutKey{}).(time.Duration); ok {
+		timeout = v
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err = DecodeResponseBody(resp, c.opts.MaxResponseSize); err != nil && !errors.Is(err, ErrUnsupportedContentEncoding) {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	if c.opts.MaxResponseSize > 0 && !resp.Uncompressed {
+		// not decoded, only limited
+		resp.Body, _ = NewContentDecodingReader(resp.Body, "", c.opts.MaxResponseSize)
+	}
+	resp.Body = &cancelOnCloseReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *HTTPClient) isRetryStatus(status int) bool {
+	for _, code := range c.opts.RetryStatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdempotentRequest reports whether req can be sent again safely (RFC 9110 9.2.2).
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(HTTPHeaderIdempotencyKey) != ""
+}
+
+type cancelOnCloseReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// DoJSON sends in as a JSON body if not nil, and decodes a JSON response into out if not nil.
+// A response of status >= 400 results in *HTTPStatusError.
+func (c *HTTPClient) DoJSON(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(bs)
+	}
+	req, err := c.NewRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set(HTTPHeaderContentType, ContentTypeJSON)
+	}
+	req.Header.Set(HTTPHeaderAccept, ContentTypeJSON)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ReadDecodedResponseBody(resp)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: respBody}
+	}
+	if out != nil && len(respBody) > 0 {
+		if err = json.Unmarshal(respBody, out); err != nil {
+			return resp, fmt.Errorf("decode response of %s %s: %w", method, req.URL, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *HTTPClient) GetJSON(ctx context.Context, path string, out interface{}) error {
+	_, err := c.DoJSON(ctx, http.MethodGet, path, nil, out)
+	return err
+}
+
+func (c *HTTPClient) PostJSON(ctx context.Context, path string, in, out interface{}) error {
+	_, err := c.DoJSON(ctx, http.MethodPost, path, in, out)
+	return err
+}
+
+func (c *HTTPClient) PutJSON(ctx context.Context, path string, in, out interface{}) error {
+	_, err := c.DoJSON(ctx, http.MethodPut, path, in, out)
+	return err
+}
+
+func (c *HTTPClient) DeleteJSON(ctx context.Context, path string, out interface{}) error {
+	_, err := c.DoJSON(ctx, http.MethodDelete, path, nil, out)
+	return err
+}
+
+// LoggingMiddleware logs every request with its status and duration to logger, or the standard logger if nil.
+func LoggingMiddleware(logger *log.Logger) RoundTripperMiddleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				logger.Printf("%s %s failed after %v: %v", req.Method, req.URL, time.Since(start), err)
+			} else {
+				logger.Printf("%s %s %d %v", req.Method, req.URL, resp.StatusCode, time.Since(start))
+			}
+			return resp, err
+		})
+	}
+}
+
+// BearerAuthMiddleware sets the Authorization header of requests from token, which is called per request.
+func BearerAuthMiddleware(token func(ctx context.Context) (string, error)) RoundTripperMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			value, err := token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set(HTTPHeaderAuthorization, "Bearer "+value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// MetricsMiddleware calls observe after every round trip, e.g. to feed a latency histogram.
+func MetricsMiddleware(observe func(req *http.Request, resp *http.Response, err error, duration time.Duration)) RoundTripperMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			observe(req, resp, err, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// CircuitBreakerMiddleware guards requests with one CircuitBreaker per host, see NewCircuitBreakerTransport.
+func CircuitBreakerMiddleware(opts CircuitBreakerOptions) RoundTripperMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewCircuitBreakerTransport(next, opts)
+	}
+}
+
+// BulkheadMiddleware limits concurrent requests per host, see NewBulkheadTransport.
+func BulkheadMiddleware(opts BulkheadOptions) RoundTripperMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewBulkheadTransport(next, opts)
+	}
+}
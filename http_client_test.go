@@ -0,0 +1,170 @@
+package utility
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/api/users", req.URL.Path)
+		assert.Equal(t, "test", req.Header.Get("User-Agent"))
+		assert.Equal(t, ContentTypeJSON, req.Header.Get(HTTPHeaderAccept))
+		if req.Method == http.MethodPost {
+			assert.Equal(t, ContentTypeJSON, req.Header.Get(HTTPHeaderContentType))
+			body, _ := io.ReadAll(req.Body)
+			assert.Equal(t, `{"name":"a"}`, string(body))
+			http.Error(w, `{"error":"exists"}`, http.StatusConflict)
+			return
+		}
+		w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte(`[{"name":"a"}]`))
+		_ = zw.Close()
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(HTTPClientOptions{
+		BaseURL: server.URL + "/api/",
+		Header:  http.Header{"User-Agent": {"test"}},
+	})
+	var users []StrMap
+	assert.Nil(t, client.GetJSON(context.Background(), "/users", &users))
+	assert.Equal(t, []StrMap{{"name": "a"}}, users)
+
+	err := client.PostJSON(context.Background(), "users", StrMap{"name": "a"}, nil)
+	var statusErr *HTTPStatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusConflict, statusErr.StatusCode)
+	assert.Equal(t, "{\"error\":\"exists\"}\n", string(statusErr.Body))
+}
+
+func TestHTTPClientBodylessResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, []string{"a"}, req.Header.Values("X-Default"))
+		w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+		if req.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	header := http.Header{"X-Default": {"a"}}
+	client := NewHTTPClient(HTTPClientOptions{BaseURL: server.URL, Header: header, MaxResponseSize: 10})
+	for _, c := range []struct{ method, path string }{{http.MethodHead, "/"}, {http.MethodGet, "/empty"}} {
+		req, _ := client.NewRequest(context.Background(), c.method, c.path, nil)
+		resp, err := client.Do(req)
+		assert.Nil(t, err, c.method)
+		if err != nil {
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err, c.method)
+		assert.Empty(t, body, c.method)
+		_ = resp.Body.Close()
+
+		// the default header is copied into requests, not shared
+		req.Header.Add("X-Default", "b")
+		req.Header["X-Default"][0] = "c"
+	}
+	assert.Equal(t, http.Header{"X-Default": {"a"}}, header)
+}
+
+func TestHTTPClientRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		assert.Equal(t, "payload", string(body))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(HTTPClientOptions{
+		BaseURL: server.URL,
+		Retry:   &RetryPolicy{MaxAttempts: 3, Backoff: ConstantBackoff(time.Millisecond)},
+	})
+	req, _ := client.NewRequest(context.Background(), http.MethodPut, "x", bytes.NewReader([]byte("payload")))
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int32(3), calls)
+
+	// exhausted retries return the last response
+	atomic.StoreInt32(&calls, -10)
+	req, _ = client.NewRequest(context.Background(), http.MethodPut, "x", bytes.NewReader([]byte("payload")))
+	resp, err = client.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(-7), calls)
+
+	// POST is not idempotent
+	atomic.StoreInt32(&calls, 0)
+	req, _ = client.NewRequest(context.Background(), http.MethodPost, "x", bytes.NewReader([]byte("payload")))
+	resp, err = client.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestHTTPClientLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		_, _ = w.Write(bytes.Repeat([]byte("a"), 100))
+	}))
+	defer server.Close()
+
+	var observed int32
+	var auth string
+	client := NewHTTPClient(HTTPClientOptions{
+		BaseURL:         server.URL,
+		MaxResponseSize: 10,
+		Timeout:         time.Second,
+		Middlewares: []RoundTripperMiddleware{
+			MetricsMiddleware(func(req *http.Request, resp *http.Response, err error, duration time.Duration) {
+				atomic.AddInt32(&observed, 1)
+			}),
+			BearerAuthMiddleware(func(ctx context.Context) (string, error) {
+				return "token", nil
+			}),
+			func(next http.RoundTripper) http.RoundTripper {
+				return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					auth = req.Header.Get(HTTPHeaderAuthorization)
+					return next.RoundTrip(req)
+				})
+			},
+		},
+	})
+	req, _ := client.NewRequest(context.Background(), http.MethodGet, "/", nil)
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	_, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	var limitErr *DecodedSizeLimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, int32(1), observed)
+
+	req, _ = client.NewRequest(WithHTTPTimeout(context.Background(), 10*time.Millisecond), http.MethodGet, "/slow", nil)
+	_, err = client.Do(req)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
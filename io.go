@@ -67,6 +67,35 @@ func ReadBytes(fp io.Reader) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ReadAllFromReadCloserPooled is ReadAllFromReadCloser reading into a pooled buffer. The returned
+// slice is only valid until release is called, which gives the buffer back; release is never nil.
+func ReadAllFromReadCloserPooled(r io.ReadCloser) (bs []byte, release func(), err error) {
+	buf := GetBuffer()
+	_, err = buf.ReadFrom(r)
+	return buf.Bytes(), func() { PutBuffer(buf) }, err
+}
+
+// ReadBytesPooled is ReadBytes reading into a pooled buffer, see ReadAllFromReadCloserPooled
+// for release. On error the buffer is already given back.
+func ReadBytesPooled(fp io.Reader) (bs []byte, release func(), err error) {
+	buf := GetBuffer()
+	if _, err = buf.ReadFrom(fp); err != nil {
+		PutBuffer(buf)
+		return nil, func() {}, err
+	}
+	return buf.Bytes(), func() { PutBuffer(buf) }, nil
+}
+
+// readBytesCopy is ReadBytes reading through a pooled buffer, it only allocates the returned slice.
+func readBytesCopy(fp io.Reader) ([]byte, error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if _, err := buf.ReadFrom(fp); err != nil {
+		return nil, err
+	}
+	return copyBufferBytes(buf), nil
+}
+
 func ReadFileFromPath(path string) ([]byte, error) {
 	fp, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
@@ -0,0 +1,433 @@
+package utility
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/kyl2016/utility/crypto"
+)
+
+// JWE key management algorithms (RFC 7518 4.1) and content encryption (RFC 7518 5.1).
+const (
+	JWEAlgDirect     = "dir"
+	JWEAlgRSAOAEP256 = "RSA-OAEP-256"
+	JWEAlgECDHES     = "ECDH-ES"
+	JWEAlgA256KW     = "A256KW"
+	JWEEncA256GCM    = "A256GCM"
+
+	jweHeaderEncryption      = "enc"
+	jweHeaderContentType     = "cty"
+	jweHeaderEphemeralKey    = "epk"
+	jweHeaderPartyUInfo      = "apu"
+	jweHeaderPartyVInfo      = "apv"
+	jweHeaderCompression     = "zip"
+	jweContentTypeJWT        = "JWT"
+	jweContentEncryptKeySize = 32
+	jweTagSize               = 16
+)
+
+const (
+	ErrorCodeJWEMalformed        ErrorCode = "jwe_malformed"
+	ErrorCodeJWEDecryptionFailed ErrorCode = "jwe_decryption_failed"
+)
+
+var (
+	ErrJWEMalformed        = newJWTError("malformed encrypted token", ErrorCodeJWEMalformed)
+	ErrJWEDecryptionFailed = newJWTError("decryption failed", ErrorCodeJWEDecryptionFailed)
+)
+
+// JWEKey is a key bound to a key management algorithm, like JWTKey.
+type JWEKey struct {
+	alg string
+	id  string
+	// decryptKey is nil for encryption keys.
+	encryptKey interface{}
+	decryptKey interface{}
+}
+
+// NewJWEKey binds key to alg. Keys are 32 bytes secrets for dir and A256KW, *rsa.PrivateKey or
+// *rsa.PublicKey of 2048 bits or more for RSA-OAEP-256, *ecdsa.PrivateKey or *ecdsa.PublicKey
+// of P-256, P-384 or P-521 for ECDH-ES. Public keys only encrypt tokens.
+func NewJWEKey(alg string, key interface{}) (*JWEKey, error) {
+	invalid := func(reason string) (*JWEKey, error) {
+		return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": reason})
+	}
+	k := &JWEKey{alg: alg}
+	switch v := key.(type) {
+	case []byte:
+		if alg != JWEAlgDirect && alg != JWEAlgA256KW {
+			return invalid("secret key")
+		}
+		if len(v) != jweContentEncryptKeySize {
+			return invalid("secret key size")
+		}
+		k.encryptKey, k.decryptKey = v, v
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		if alg != JWEAlgRSAOAEP256 {
+			return invalid("rsa key")
+		}
+		public, _ := v.(*rsa.PublicKey)
+		if private, ok := v.(*rsa.PrivateKey); ok {
+			public = &private.PublicKey
+			k.decryptKey = private
+		}
+		if public.N.BitLen() < minJWTRSAKeyBits {
+			return invalid("rsa key size")
+		}
+		k.encryptKey = public
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		if alg != JWEAlgECDHES {
+			return invalid("ecdsa key")
+		}
+		public, _ := v.(*ecdsa.PublicKey)
+		if private, ok := v.(*ecdsa.PrivateKey); ok {
+			public = &private.PublicKey
+			k.decryptKey = private
+		}
+		if jweCurveAlg(public.Curve) == "" {
+			return invalid("ecdsa key curve")
+		}
+		k.encryptKey = public
+	default:
+		if alg != JWEAlgDirect && alg != JWEAlgA256KW && alg != JWEAlgRSAOAEP256 && alg != JWEAlgECDHES {
+			return invalid("unsupported algorithm")
+		}
+		return invalid("unsupported key type")
+	}
+	return k, nil
+}
+
+// ParseJWEKeyPEM reads a PEM key for RSA-OAEP-256, see crypto.ParseRSAPrivateKey and
+// crypto.ParseRSAPublicKey, or for ECDH-ES, see ParseJWTKeyPEM.
+func ParseJWEKeyPEM(alg string, data []byte) (*JWEKey, error) {
+	switch alg {
+	case JWEAlgRSAOAEP256:
+		if privateKey, err := crypto.ParseRSAPrivateKey(data); err == nil {
+			return NewJWEKey(alg, privateKey)
+		}
+		publicKey, err := crypto.ParseRSAPublicKey(data)
+		if err != nil {
+			return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": err.Error()})
+		}
+		return NewJWEKey(alg, publicKey)
+	case JWEAlgECDHES:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": "no PEM block"})
+		}
+		key, err := parseDERKey(block.Bytes)
+		if err != nil {
+			return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": err.Error()})
+		}
+		return NewJWEKey(alg, key)
+	}
+	return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": "not a PEM key algorithm"})
+}
+
+func (k *JWEKey) Alg() string {
+	return k.alg
+}
+
+// ID is the kid header of the tokens encrypted with k, see WithID.
+func (k *JWEKey) ID() string {
+	return k.id
+}
+
+// WithID returns a copy of k identified by kid.
+func (k *JWEKey) WithID(kid string) *JWEKey {
+	c := *k
+	c.id = kid
+	return &c
+}
+
+// CanDecrypt reports whether k holds a secret or private key.
+func (k *JWEKey) CanDecrypt() bool {
+	return k.decryptKey != nil
+}
+
+// Public returns k without its private key, the secret of dir and A256KW keys is kept.
+func (k *JWEKey) Public() *JWEKey {
+	if _, ok := k.encryptKey.([]byte); ok {
+		return k
+	}
+	return &JWEKey{alg: k.alg, id: k.id, encryptKey: k.encryptKey}
+}
+
+// EncryptJWE encrypts plaintext with A256GCM and a content key managed by key, in the compact
+// serialization (RFC 7516 7.1). header adds parameters to the protected header, e.g. cty, or apu
+// and apv for ECDH-ES. zip isn't supported.
+func EncryptJWE(key *JWEKey, plaintext []byte, header StrMap) (string, error) {
+	if _, ok := header[jweHeaderCompression]; ok {
+		return "", ErrJWEMalformed.WithField("error", "unsupported zip header")
+	}
+	protected := StrMap{}
+	for name, value := range header {
+		protected[name] = value
+	}
+	protected[jwtHeaderAlgorithm] = key.alg
+	protected[jweHeaderEncryption] = JWEEncA256GCM
+	if key.id != "" {
+		protected[jwtHeaderKeyID] = key.id
+	}
+
+	var cek, encryptedKey []byte
+	var err error
+	switch key.alg {
+	case JWEAlgDirect:
+		cek = key.encryptKey.([]byte)
+	case JWEAlgA256KW:
+		if cek, err = randomBytes(jweContentEncryptKeySize); err == nil {
+			encryptedKey, err = crypto.AESKeyWrap(key.encryptKey.([]byte), cek)
+		}
+	case JWEAlgRSAOAEP256:
+		if cek, err = randomBytes(jweContentEncryptKeySize); err == nil {
+			encryptedKey, err = crypto.NewRSAEncoderWithKey(key.encryptKey.(*rsa.PublicKey)).EncryptOAEP(cek)
+		}
+	case JWEAlgECDHES:
+		public := key.encryptKey.(*ecdsa.PublicKey)
+		var apu, apv []byte
+		if apu, apv, err = jwePartyInfo(protected); err != nil {
+			return "", err
+		}
+		var ephemeral *ecdsa.PrivateKey
+		if ephemeral, err = ecdsa.GenerateKey(public.Curve, rand.Reader); err == nil {
+			epk := (&JWTKey{verifyKey: &ephemeral.PublicKey}).publicJWK()
+			protected[jweHeaderEphemeralKey] = &JWK{KeyType: epk.KeyType, Curve: epk.Curve, X: epk.X, Y: epk.Y}
+			cek = jweConcatKDF(ecdhSharedSecret(public, ephemeral), JWEEncA256GCM, apu, apv, jweContentEncryptKeySize)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	headerJSON, err := json.Marshal(protected)
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := jwsEncoding.EncodeToString(headerJSON)
+	coder, err := crypto.NewAESCoderWithGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv, err := randomBytes(coder.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	ciphertext, tag, err := coder.Seal(iv, plaintext, []byte(encodedHeader))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		encodedHeader,
+		jwsEncoding.EncodeToString(encryptedKey),
+		jwsEncoding.EncodeToString(iv),
+		jwsEncoding.EncodeToString(ciphertext),
+		jwsEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptJWE decrypts a compact JWE of the algorithm of key and A256GCM, it returns the plaintext
+// and the protected header.
+func DecryptJWE(token string, key *JWEKey) ([]byte, StrMap, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, ErrJWEMalformed
+	}
+	var segments [5][]byte
+	for i, part := range parts {
+		var err error
+		if segments[i], err = jwsEncoding.DecodeString(part); err != nil {
+			return nil, nil, ErrJWEMalformed.WithField("error", err.Error())
+		}
+	}
+	header := StrMap{}
+	if err := json.Unmarshal(segments[0], &header); err != nil {
+		return nil, nil, ErrJWEMalformed.WithField("error", err.Error())
+	}
+	// no extension is supported (RFC 7516 4.1.13)
+	if _, ok := header[jwtHeaderCritical]; ok {
+		return nil, nil, ErrJWEMalformed.WithField("error", "unsupported crit header")
+	}
+	// nor compression, the plaintext would be returned compressed
+	if _, ok := header[jweHeaderCompression]; ok {
+		return nil, nil, ErrJWEMalformed.WithField("error", "unsupported zip header")
+	}
+	if alg, _ := header[jwtHeaderAlgorithm].(string); alg != key.alg {
+		return nil, nil, fmt.Errorf("key management algorithm invalid %w: %v", ErrJWTAlgInvalid, header[jwtHeaderAlgorithm])
+	}
+	if enc, _ := header[jweHeaderEncryption].(string); enc != JWEEncA256GCM {
+		return nil, nil, fmt.Errorf("content encryption invalid %w: %v", ErrJWTAlgInvalid, header[jweHeaderEncryption])
+	}
+	if kid, _ := header[jwtHeaderKeyID].(string); kid != "" && key.id != "" && kid != key.id {
+		return nil, nil, ErrJWTKeyNotFound.WithField("kid", kid)
+	}
+	if !key.CanDecrypt() {
+		return nil, nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": key.alg, "reason": "encryption key"})
+	}
+
+	encryptedKey := segments[1]
+	var cek []byte
+	var err error
+	switch key.alg {
+	case JWEAlgDirect:
+		if len(encryptedKey) != 0 {
+			return nil, nil, ErrJWEMalformed.WithField("error", "encrypted key with dir")
+		}
+		cek = key.decryptKey.([]byte)
+	case JWEAlgA256KW:
+		cek, err = crypto.AESKeyUnwrap(key.decryptKey.([]byte), encryptedKey)
+	case JWEAlgRSAOAEP256:
+		cek, err = crypto.NewRSADecoderWithKey(key.decryptKey.(*rsa.PrivateKey)).DecryptOAEP(encryptedKey)
+		if err != nil || len(cek) != jweContentEncryptKeySize {
+			// go on with a random key so that a bad encrypted key fails as a bad tag, in the same
+			// time, and isn't an oracle on the RSA key (RFC 7516 11.5)
+			cek, err = randomBytes(jweContentEncryptKeySize)
+		}
+	case JWEAlgECDHES:
+		if len(encryptedKey) != 0 {
+			return nil, nil, ErrJWEMalformed.WithField("error", "encrypted key with ECDH-ES")
+		}
+		cek, err = jweAgreedKey(key.decryptKey.(*ecdsa.PrivateKey), header)
+	}
+	if err != nil || len(cek) != jweContentEncryptKeySize {
+		return nil, nil, ErrJWEDecryptionFailed
+	}
+
+	coder, err := crypto.NewAESCoderWithGCM(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(segments[2]) != coder.NonceSize() || len(segments[4]) != jweTagSize {
+		return nil, nil, ErrJWEMalformed.WithField("error", "iv or tag size")
+	}
+	plaintext, err := coder.Open(segments[2], segments[3], segments[4], []byte(parts[0]))
+	if err != nil {
+		return nil, nil, ErrJWEDecryptionFailed
+	}
+	return plaintext, header, nil
+}
+
+// MakeEncryptedJWTToken signs payload with signKey, then encrypts the token with encryptKey,
+// as a nested JWT (RFC 7519 5.2), so that the claims are both authenticated and confidential.
+func MakeEncryptedJWTToken(signKey *JWTKey, encryptKey *JWEKey, payload StrMap) (string, error) {
+	token, err := MakeJWTTokenWithKey(signKey, payload)
+	if err != nil {
+		return "", err
+	}
+	return EncryptJWE(encryptKey, []byte(token), StrMap{jweHeaderContentType: jweContentTypeJWT})
+}
+
+// ParseEncryptedJWTPayload decrypts a token of MakeEncryptedJWTToken with decryptKey, then
+// verifies the nested token with verifier.
+func ParseEncryptedJWTPayload(tokenStr string, decryptKey *JWEKey, verifier *JWTVerifier) (StrMap, error) {
+	token, header, err := DecryptJWE(tokenStr, decryptKey)
+	if err != nil {
+		return nil, err
+	}
+	if cty, _ := header[jweHeaderContentType].(string); !strings.EqualFold(cty, jweContentTypeJWT) {
+		return nil, ErrJWEMalformed.WithField("cty", header[jweHeaderContentType])
+	}
+	return verifier.Parse(string(token))
+}
+
+// jweAgreedKey derives the content key of ECDH-ES from the epk header (RFC 7518 4.6).
+func jweAgreedKey(private *ecdsa.PrivateKey, header StrMap) ([]byte, error) {
+	data, err := json.Marshal(header[jweHeaderEphemeralKey])
+	if err != nil {
+		return nil, err
+	}
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	if jwk.KeyType != JWKKeyTypeEC || jwk.D != "" {
+		return nil, ErrJWKInvalid.WithField("reason", "epk")
+	}
+	jwk.Algorithm = ""
+	ephemeral, err := jwk.JWTKey()
+	if err != nil {
+		return nil, err
+	}
+	public := ephemeral.verifyKey.(*ecdsa.PublicKey)
+	if public.Curve != private.Curve {
+		return nil, ErrJWKInvalid.WithField("reason", "epk curve")
+	}
+	apu, apv, err := jwePartyInfo(header)
+	if err != nil {
+		return nil, err
+	}
+	return jweConcatKDF(ecdhSharedSecret(public, private), JWEEncA256GCM, apu, apv, jweContentEncryptKeySize), nil
+}
+
+// jwePartyInfo decodes the apu and apv headers of ECDH-ES, which are base64url strings.
+func jwePartyInfo(header StrMap) (apu, apv []byte, err error) {
+	for name, info := range map[string]*[]byte{jweHeaderPartyUInfo: &apu, jweHeaderPartyVInfo: &apv} {
+		value, ok := header[name]
+		if !ok {
+			continue
+		}
+		encoded, ok := value.(string)
+		if !ok {
+			return nil, nil, ErrJWEMalformed.WithField("error", name+" is not a string")
+		}
+		if *info, err = jwsEncoding.DecodeString(encoded); err != nil {
+			return nil, nil, ErrJWEMalformed.WithField("error", name+": "+err.Error())
+		}
+	}
+	return apu, apv, nil
+}
+
+// ecdhSharedSecret returns the x coordinate of the product, of the size of the curve.
+func ecdhSharedSecret(public *ecdsa.PublicKey, private *ecdsa.PrivateKey) []byte {
+	x, _ := public.Curve.ScalarMult(public.X, public.Y, private.D.Bytes())
+	return x.FillBytes(make([]byte, ecdsaKeySize(public.Curve)))
+}
+
+// jweConcatKDF is the Concat KDF of NIST SP 800-56A with SHA-256, as profiled by RFC 7518 4.6.2.
+func jweConcatKDF(z []byte, algorithmID string, apu, apv []byte, size int) []byte {
+	uint32Bytes := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b
+	}
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(algorithmID), apu, apv} {
+		otherInfo = append(append(otherInfo, uint32Bytes(len(field))...), field...)
+	}
+	otherInfo = append(otherInfo, uint32Bytes(size*8)...)
+
+	var key []byte
+	for counter := 1; len(key) < size; counter++ {
+		h := sha256.New()
+		h.Write(uint32Bytes(counter))
+		h.Write(z)
+		h.Write(otherInfo)
+		key = h.Sum(key)
+	}
+	return key[:size]
+}
+
+func jweCurveAlg(curve elliptic.Curve) string {
+	for _, alg := range []string{JWTAlgES256, JWTAlgES384, JWTAlgES512} {
+		if jwtAlgCurve(alg) == curve {
+			return alg
+		}
+	}
+	return ""
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,154 @@
+package utility
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/kyl2016/utility/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWERoundTrip(t *testing.T) {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	_, privatePEM, err := crypto.NewRSAKeys(2048)
+	assert.NoError(t, err)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	direct, err := NewJWEKey(JWEAlgDirect, secret)
+	assert.NoError(t, err)
+	wrap, err := NewJWEKey(JWEAlgA256KW, secret)
+	assert.NoError(t, err)
+	rsaKey, err := ParseJWEKeyPEM(JWEAlgRSAOAEP256, privatePEM)
+	assert.NoError(t, err)
+	ecdhKey, err := NewJWEKey(JWEAlgECDHES, ecKey)
+	assert.NoError(t, err)
+
+	for _, key := range []*JWEKey{direct, wrap, rsaKey, ecdhKey.WithID("k1")} {
+		token, err := EncryptJWE(key.Public(), []byte("personal data"), StrMap{"typ": "secret"})
+		assert.NoError(t, err, key.Alg())
+		assert.Len(t, strings.Split(token, "."), 5, key.Alg())
+
+		plaintext, header, err := DecryptJWE(token, key)
+		assert.NoError(t, err, key.Alg())
+		assert.Equal(t, "personal data", string(plaintext), key.Alg())
+		assert.Equal(t, "secret", header["typ"], key.Alg())
+		assert.Equal(t, key.Alg(), header["alg"], key.Alg())
+
+		// the header is authenticated
+		parts := strings.Split(token, ".")
+		parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + key.Alg() + `","enc":"A256GCM","typ":"x"}`))
+		_, _, err = DecryptJWE(strings.Join(parts, "."), key)
+		assert.Error(t, err, key.Alg())
+	}
+
+	token, _ := EncryptJWE(wrap, []byte("data"), nil)
+	_, _, err = DecryptJWE(token, direct)
+	assert.True(t, errors.Is(err, ErrJWTAlgInvalid))
+	other, _ := NewJWEKey(JWEAlgA256KW, make([]byte, 32))
+	_, _, err = DecryptJWE(token, other)
+	assert.True(t, errors.Is(err, ErrJWEDecryptionFailed))
+	_, _, err = DecryptJWE("a.b.c", wrap)
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+
+	token, _ = EncryptJWE(rsaKey.Public(), []byte("data"), nil)
+	_, _, err = DecryptJWE(token, rsaKey.Public())
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+
+	// a bad RSA encrypted key fails as a bad tag
+	parts := strings.Split(token, ".")
+	parts[1] = jwsEncoding.EncodeToString(make([]byte, 256))
+	_, _, err = DecryptJWE(strings.Join(parts, "."), rsaKey)
+	assert.True(t, errors.Is(err, ErrJWEDecryptionFailed))
+
+	// non canonical base64url
+	parts = strings.Split(token, ".")
+	tag := parts[4]
+	parts[4] = tag[:len(tag)-1] + string(jweBase64Alphabet[strings.IndexByte(jweBase64Alphabet, tag[len(tag)-1])|1])
+	_, _, err = DecryptJWE(strings.Join(parts, "."), rsaKey)
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+
+	token, err = EncryptJWE(wrap, []byte("data"), StrMap{"crit": []string{"exp"}, "exp": 1})
+	assert.NoError(t, err)
+	_, _, err = DecryptJWE(token, wrap)
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+
+	// compression isn't supported
+	_, err = EncryptJWE(wrap, []byte("data"), StrMap{"zip": "DEF"})
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+	parts = strings.Split(token, ".")
+	parts[0] = jwsEncoding.EncodeToString([]byte(`{"alg":"A256KW","enc":"A256GCM","zip":"DEF"}`))
+	_, _, err = DecryptJWE(strings.Join(parts, "."), wrap)
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+
+	// apu and apv are used on both sides of ECDH-ES
+	info := StrMap{"apu": jwsEncoding.EncodeToString([]byte("Alice")), "apv": jwsEncoding.EncodeToString([]byte("Bob"))}
+	token, err = EncryptJWE(ecdhKey.Public(), []byte("data"), info)
+	assert.NoError(t, err)
+	plaintext, header, err := DecryptJWE(token, ecdhKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(plaintext))
+	assert.Equal(t, info["apu"], header["apu"])
+	for _, bad := range []StrMap{{"apu": "A==="}, {"apv": 1}} {
+		_, err = EncryptJWE(ecdhKey.Public(), []byte("data"), bad)
+		assert.True(t, errors.Is(err, ErrJWEMalformed), bad)
+	}
+}
+
+const jweBase64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func TestNestedJWT(t *testing.T) {
+	signKey, _ := GenerateJWTKey(JWTAlgEdDSA)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encryptKey, _ := NewJWEKey(JWEAlgECDHES, ecKey)
+
+	token, err := MakeEncryptedJWTToken(signKey, encryptKey.Public(), StrMap{"email": "a@example.com"})
+	assert.NoError(t, err)
+	assert.NotContains(t, token, base64.RawURLEncoding.EncodeToString([]byte("a@example.com")))
+
+	verifier := &JWTVerifier{Algorithms: []string{JWTAlgEdDSA}, Keys: []*JWTKey{signKey.Public()}}
+	payload, err := ParseEncryptedJWTPayload(token, encryptKey, verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, "a@example.com", payload["email"])
+
+	plain, _ := EncryptJWE(encryptKey, []byte("not a token"), nil)
+	_, err = ParseEncryptedJWTPayload(plain, encryptKey, verifier)
+	assert.True(t, errors.Is(err, ErrJWEMalformed))
+}
+
+func TestNewJWEKey(t *testing.T) {
+	_, err := NewJWEKey(JWEAlgDirect, make([]byte, 16))
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = NewJWEKey(JWEAlgRSAOAEP256, make([]byte, 32))
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	_, err = NewJWEKey(JWEAlgECDHES, ecKey)
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = NewJWEKey("RSA1_5", "key")
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+}
+
+func TestJWEConcatKDF(t *testing.T) {
+	// RFC 7518 Appendix C
+	decode := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	curve := elliptic.P256()
+	bob := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     decode("weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ"),
+		Y:     decode("e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck"),
+	}
+	alice := &ecdsa.PrivateKey{D: decode("0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo")}
+	alice.Curve = curve
+
+	key := jweConcatKDF(ecdhSharedSecret(bob, alice), "A128GCM", []byte("Alice"), []byte("Bob"), 16)
+	assert.Equal(t, "VqqN6vgjbSBcIijNcacQGg", base64.RawURLEncoding.EncodeToString(key))
+}
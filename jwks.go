@@ -0,0 +1,559 @@
+package utility
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWK key types (RFC 7518 6.1, RFC 8037 2).
+const (
+	JWKKeyTypeRSA = "RSA"
+	JWKKeyTypeEC  = "EC"
+	JWKKeyTypeOKP = "OKP"
+	JWKKeyTypeOct = "oct"
+
+	jwkUseSignature = "sig"
+	jwkCurveEd25519 = "Ed25519"
+
+	maxJWKSetSize = 1 << 20
+)
+
+const ErrorCodeJWKInvalid ErrorCode = "jwk_invalid"
+
+var ErrJWKInvalid = NewError("invalid JWK").
+	WithCode(ErrorCodeJWKInvalid).
+	WithCategory(ErrorCategoryInvalidArgument)
+
+// JWK is a JSON Web Key (RFC 7517), its big integers and binary members are base64url encoded.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// Curve, X and Y are the public key of EC and OKP keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+	// N and E are the public key of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// D is the private key of RSA, EC and OKP keys, with the primes of RSA keys.
+	D  string `json:"d,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+	// K is the secret of oct keys.
+	K string `json:"k,omitempty"`
+}
+
+// JWKSet is a JWK Set, published by JWKS endpoints.
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWK returns k with its private key or secret.
+func (k *JWTKey) JWK() *JWK {
+	jwk := k.publicJWK()
+	switch key := k.signKey.(type) {
+	case []byte:
+		jwk.K = encodeJWKBytes(key)
+	case *rsa.PrivateKey:
+		jwk.D = encodeJWKInt(key.D)
+		if len(key.Primes) == 2 {
+			p, q := key.Primes[0], key.Primes[1]
+			one := big.NewInt(1)
+			jwk.P = encodeJWKInt(p)
+			jwk.Q = encodeJWKInt(q)
+			jwk.DP = encodeJWKInt(new(big.Int).Mod(key.D, new(big.Int).Sub(p, one)))
+			jwk.DQ = encodeJWKInt(new(big.Int).Mod(key.D, new(big.Int).Sub(q, one)))
+			jwk.QI = encodeJWKInt(new(big.Int).ModInverse(q, p))
+		}
+	case *ecdsa.PrivateKey:
+		jwk.D = encodeJWKBytes(key.D.FillBytes(make([]byte, ecdsaKeySize(key.Curve))))
+	case ed25519.PrivateKey:
+		jwk.D = encodeJWKBytes(key.Seed())
+	}
+	return jwk
+}
+
+// PublicJWK returns the public key of k, nil for HS keys which are secrets.
+func (k *JWTKey) PublicJWK() *JWK {
+	if jwtAlgFamily(k.alg) == "HS" {
+		return nil
+	}
+	return k.publicJWK()
+}
+
+func (k *JWTKey) publicJWK() *JWK {
+	jwk := &JWK{KeyID: k.id, Use: jwkUseSignature, Algorithm: k.alg}
+	switch key := k.verifyKey.(type) {
+	case []byte:
+		jwk.KeyType = JWKKeyTypeOct
+	case *rsa.PublicKey:
+		jwk.KeyType = JWKKeyTypeRSA
+		jwk.N = encodeJWKInt(key.N)
+		jwk.E = encodeJWKInt(big.NewInt(int64(key.E)))
+	case *ecdsa.PublicKey:
+		size := ecdsaKeySize(key.Curve)
+		jwk.KeyType = JWKKeyTypeEC
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = encodeJWKBytes(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeJWKBytes(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = JWKKeyTypeOKP
+		jwk.Curve = jwkCurveEd25519
+		jwk.X = encodeJWKBytes(key)
+	}
+	return jwk
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the public key of k, base64url encoded.
+// It makes a stable kid.
+func (k *JWTKey) Thumbprint() string {
+	jwk := k.publicJWK()
+	var members interface{}
+	// the required members only, in lexicographic order
+	switch jwk.KeyType {
+	case JWKKeyTypeRSA:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case JWKKeyTypeEC:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case JWKKeyTypeOKP:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		members = struct {
+			K   string `json:"k"`
+			Kty string `json:"kty"`
+		}{encodeJWKBytes(k.verifyKey.([]byte)), JWKKeyTypeOct}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return encodeJWKBytes(sum[:])
+}
+
+// JWTKey decodes j. The algorithm defaults to RS256 for RSA keys, HS256 for oct ones,
+// to the one of the curve for the others.
+func (j *JWK) JWTKey() (*JWTKey, error) {
+	invalid := func(reason string) (*JWTKey, error) {
+		return nil, ErrJWKInvalid.WithFields(StrMap{"kid": j.KeyID, "reason": reason})
+	}
+	var key interface{}
+	alg := j.Algorithm
+	switch j.KeyType {
+	case JWKKeyTypeOct:
+		secret, err := decodeJWKBytes(j.K)
+		if err != nil {
+			return invalid("k")
+		}
+		key = secret
+		if alg == "" {
+			alg = JWTAlgHS256
+		}
+	case JWKKeyTypeRSA:
+		n, errN := decodeJWKInt(j.N)
+		e, errE := decodeJWKInt(j.E)
+		if errN != nil || errE != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return invalid("n or e")
+		}
+		public := rsa.PublicKey{N: n, E: int(e.Int64())}
+		key = &public
+		if j.D != "" {
+			d, errD := decodeJWKInt(j.D)
+			p, errP := decodeJWKInt(j.P)
+			q, errQ := decodeJWKInt(j.Q)
+			if errD != nil || errP != nil || errQ != nil {
+				return invalid("d, p or q")
+			}
+			private := &rsa.PrivateKey{PublicKey: public, D: d, Primes: []*big.Int{p, q}}
+			if err := private.Validate(); err != nil {
+				return invalid(err.Error())
+			}
+			private.Precompute()
+			key = private
+		}
+		if alg == "" {
+			alg = JWTAlgRS256
+		}
+	case JWKKeyTypeEC:
+		var curve elliptic.Curve
+		for _, a := range []string{JWTAlgES256, JWTAlgES384, JWTAlgES512} {
+			if c := jwtAlgCurve(a); c.Params().Name == j.Curve {
+				curve = c
+				if alg == "" {
+					alg = a
+				}
+			}
+		}
+		if curve == nil {
+			return invalid("crv")
+		}
+		x, errX := decodeJWKInt(j.X)
+		y, errY := decodeJWKInt(j.Y)
+		if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+			return invalid("x or y")
+		}
+		public := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		key = &public
+		if j.D != "" {
+			d, err := decodeJWKInt(j.D)
+			if err != nil {
+				return invalid("d")
+			}
+			if px, py := curve.ScalarBaseMult(d.Bytes()); px.Cmp(x) != 0 || py.Cmp(y) != 0 {
+				return invalid("d doesn't match x and y")
+			}
+			key = &ecdsa.PrivateKey{PublicKey: public, D: d}
+		}
+	case JWKKeyTypeOKP:
+		if j.Curve != jwkCurveEd25519 {
+			return invalid("crv")
+		}
+		x, err := decodeJWKBytes(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return invalid("x")
+		}
+		key = ed25519.PublicKey(x)
+		if j.D != "" {
+			seed, err := decodeJWKBytes(j.D)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return invalid("d")
+			}
+			private := ed25519.NewKeyFromSeed(seed)
+			if !private.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+				return invalid("d doesn't match x")
+			}
+			key = private
+		}
+		if alg == "" {
+			alg = JWTAlgEdDSA
+		}
+	default:
+		return invalid("unsupported kty")
+	}
+	k, err := NewJWTKey(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	return k.WithID(j.KeyID), nil
+}
+
+// NewJWKSet returns the public keys of keys, to be published. HS keys are left out.
+func NewJWKSet(keys ...*JWTKey) *JWKSet {
+	set := &JWKSet{Keys: []*JWK{}}
+	for _, key := range keys {
+		if jwk := key.PublicJWK(); jwk != nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func ParseJWKSet(data []byte) (*JWKSet, error) {
+	set := &JWKSet{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, ErrJWKInvalid.WithField("reason", err.Error())
+	}
+	return set, nil
+}
+
+// JWTKeys decodes the signature keys of s. As RFC 7517 5 intends, the keys that can't be used
+// are ignored: of unsupported types, of other uses or algorithms such as encryption ones, and
+// the ones that fail to decode, e.g. RSA keys of less than 2048 bits.
+func (s *JWKSet) JWTKeys() ([]*JWTKey, error) {
+	keys := make([]*JWTKey, 0, len(s.Keys))
+	for _, jwk := range s.Keys {
+		if jwk == nil || (jwk.Use != "" && jwk.Use != jwkUseSignature) {
+			continue
+		}
+		if _, ok := jwsAlgorithms[jwk.Algorithm]; jwk.Algorithm != "" && !ok {
+			continue
+		}
+		switch jwk.KeyType {
+		case JWKKeyTypeRSA, JWKKeyTypeEC, JWKKeyTypeOKP, JWKKeyTypeOct:
+		default:
+			continue
+		}
+		key, err := jwk.JWTKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ServeHTTP publishes s, e.g. at /.well-known/jwks.json.
+func (s *JWKSet) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	serveJWKSet(w, s)
+}
+
+func serveJWKSet(w http.ResponseWriter, s *JWKSet) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(HTTPHeaderContentType, ContentTypeJSON)
+	_, _ = w.Write(data)
+}
+
+// GenerateJWTKey generates a signing key for alg, identified by its thumbprint: a 2048 bits RSA
+// key, an EC key of the curve of alg, an Ed25519 key or a secret of the size of the HS hash.
+func GenerateJWTKey(alg string) (*JWTKey, error) {
+	alg = normalizedJWTAlg(alg)
+	var key interface{}
+	var err error
+	switch jwtAlgFamily(alg) {
+	case "HS":
+		secret := make([]byte, jwtHMACKeySize(alg))
+		_, err = rand.Read(secret)
+		key = secret
+	case "RS", "PS":
+		key, err = rsa.GenerateKey(rand.Reader, minJWTRSAKeyBits)
+	case "ES":
+		key, err = ecdsa.GenerateKey(jwtAlgCurve(alg), rand.Reader)
+	case JWTAlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": "unsupported algorithm"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	k, err := NewJWTKey(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	return k.WithID(k.Thumbprint()), nil
+}
+
+func jwtHMACKeySize(alg string) int {
+	switch alg {
+	case JWTAlgHS384:
+		return 48
+	case JWTAlgHS512:
+		return 64
+	}
+	return 32
+}
+
+func ecdsaKeySize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+func encodeJWKBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJWKBytes(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil && len(b) == 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func encodeJWKInt(n *big.Int) string {
+	return encodeJWKBytes(n.Bytes())
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := decodeJWKBytes(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+type JWKSCacheOptions struct {
+	// URL of the JWKS endpoint, or Path of a local JWKS file.
+	URL  string
+	Path string
+	// Client fetches URL, default http.DefaultClient, within Timeout, default 10s.
+	Client  *http.Client
+	Timeout time.Duration
+	// RefreshInterval is the lifetime of the keys, default 1h.
+	RefreshInterval time.Duration
+	// MinRefreshInterval bounds the refreshes for unknown kids and after failures, default 1m.
+	MinRefreshInterval time.Duration
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (opts *JWKSCacheOptions) normalize() {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = time.Hour
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+}
+
+// JWKSCache is a JWTKeySource of the keys of a JWKS endpoint or file. Keys are loaded on first
+// use, then refreshed when stale or when a token has an unknown kid, as rotated keys are
+// published before use. Stale keys are kept if a refresh fails. Concurrent refreshes share a
+// single fetch, during which the current keys are still served.
+type JWKSCache struct {
+	opts JWKSCacheOptions
+
+	mu          sync.Mutex
+	keys        []*JWTKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing is the refresh in flight, nil if none
+	refreshing *jwksRefresh
+}
+
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+func NewJWKSCache(opts JWKSCacheOptions) *JWKSCache {
+	opts.normalize()
+	return &JWKSCache{opts: opts}
+}
+
+// JWTKeys implements JWTKeySource, ctx bounds the fetch of a refresh along with Timeout.
+func (c *JWKSCache) JWTKeys(ctx context.Context, kid string) ([]*JWTKey, error) {
+	c.mu.Lock()
+	now := c.opts.Now()
+	canRefresh := now.Sub(c.attemptedAt) >= c.opts.MinRefreshInterval
+	stale := c.keys == nil || now.Sub(c.fetchedAt) >= c.opts.RefreshInterval
+	keys := filterJWTKeys(c.keys, kid)
+	c.mu.Unlock()
+	if !canRefresh || (!stale && (len(keys) > 0 || kid == "")) {
+		return keys, nil
+	}
+	err := c.refresh(ctx)
+	c.mu.Lock()
+	loaded := c.keys != nil
+	keys = filterJWTKeys(c.keys, kid)
+	c.mu.Unlock()
+	if !loaded && err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Refresh loads the keys now, or waits for the refresh in flight.
+func (c *JWKSCache) Refresh(ctx context.Context) error {
+	return c.refresh(ctx)
+}
+
+func (c *JWKSCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	if call := c.refreshing; call != nil {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &jwksRefresh{done: make(chan struct{})}
+	c.refreshing = call
+	attemptedAt := c.opts.Now()
+	c.attemptedAt = attemptedAt
+	c.mu.Unlock()
+
+	keys, err := c.fetch(ctx)
+	c.mu.Lock()
+	if err == nil {
+		c.keys, c.fetchedAt = keys, attemptedAt
+	}
+	call.err = err
+	c.refreshing = nil
+	c.mu.Unlock()
+	close(call.done)
+	return err
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) ([]*JWTKey, error) {
+	data, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set, err := ParseJWKSet(data)
+	if err != nil {
+		return nil, err
+	}
+	return set.JWTKeys()
+}
+
+func (c *JWKSCache) load(ctx context.Context) ([]byte, error) {
+	if c.opts.URL == "" {
+		return os.ReadFile(c.opts.Path)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.opts.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HTTPHeaderAccept, ContentTypeJSON)
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSetSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: data}
+	}
+	return data, nil
+}
+
+func filterJWTKeys(keys []*JWTKey, kid string) []*JWTKey {
+	if kid == "" {
+		return keys
+	}
+	var matched []*JWTKey
+	for _, key := range keys {
+		if key.id == kid {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,192 @@
+package utility
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, alg := range []string{JWTAlgHS256, JWTAlgRS256, JWTAlgPS256, JWTAlgES256, JWTAlgES384, JWTAlgES512, JWTAlgEdDSA} {
+		key, err := GenerateJWTKey(alg)
+		assert.NoError(t, err, alg)
+		assert.Equal(t, key.Thumbprint(), key.ID(), alg)
+
+		data, err := json.Marshal(key.JWK())
+		assert.NoError(t, err, alg)
+		var jwk JWK
+		assert.NoError(t, json.Unmarshal(data, &jwk), alg)
+		decoded, err := jwk.JWTKey()
+		assert.NoError(t, err, alg)
+		assert.True(t, decoded.CanSign(), alg)
+		assert.Equal(t, key.ID(), decoded.ID(), alg)
+
+		token, err := MakeJWTTokenWithKey(decoded, StrMap{"uid": "12"})
+		assert.NoError(t, err, alg)
+		verifyKey := key
+		if public := key.PublicJWK(); public != nil {
+			assert.Empty(t, public.D, alg)
+			verifyKey, err = public.JWTKey()
+			assert.NoError(t, err, alg)
+			assert.False(t, verifyKey.CanSign(), alg)
+		}
+		_, err = ParseJWTPayloadWithKey(token, verifyKey)
+		assert.NoError(t, err, alg)
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 3.1
+	jwk := &JWK{
+		KeyType: JWKKeyTypeRSA,
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+	}
+	key, err := jwk.JWTKey()
+	assert.NoError(t, err)
+	assert.Equal(t, JWTAlgRS256, key.Alg())
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", key.Thumbprint())
+
+	_, err = (&JWK{KeyType: JWKKeyTypeRSA, N: jwk.N, E: jwk.E, Algorithm: JWTAlgHS256}).JWTKey()
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = (&JWK{KeyType: JWKKeyTypeEC, Curve: "P-256", X: "AQ", Y: "AQ"}).JWTKey()
+	assert.True(t, errors.Is(err, ErrJWKInvalid))
+}
+
+func TestJWKSet(t *testing.T) {
+	secret, _ := GenerateJWTKey(JWTAlgHS256)
+	es, _ := GenerateJWTKey(JWTAlgES256)
+	set := NewJWKSet(secret, es)
+	assert.Len(t, set.Keys, 1)
+
+	set.Keys = append(set.Keys, &JWK{KeyType: "unknown"}, &JWK{KeyType: JWKKeyTypeEC, Use: "enc"})
+	data, _ := json.Marshal(set)
+	parsed, err := ParseJWKSet(data)
+	assert.NoError(t, err)
+	keys, err := parsed.JWTKeys()
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, es.ID(), keys[0].ID())
+	}
+
+	// keys that can't verify tokens don't reject the set
+	rs, _ := GenerateJWTKey(JWTAlgRS256)
+	encryption := *rs.PublicJWK()
+	encryption.Use, encryption.Algorithm = "", JWEAlgRSAOAEP256
+	legacy, _ := rsa.GenerateKey(rand.Reader, 1024)
+	weak := &JWK{KeyType: JWKKeyTypeRSA, KeyID: "weak", N: encodeJWKInt(legacy.N), E: encodeJWKInt(big.NewInt(int64(legacy.E)))}
+	mixed := &JWKSet{Keys: []*JWK{es.PublicJWK(), &encryption, weak, {KeyType: JWKKeyTypeEC, Algorithm: JWEAlgECDHES, Curve: "P-256"}}}
+	keys, err = mixed.JWTKeys()
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, es.ID(), keys[0].ID())
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	rotator, err := NewJWTKeyRotator(JWTKeyRotatorOptions{Alg: JWTAlgEdDSA})
+	assert.NoError(t, err)
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		rotator.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	cache := NewJWKSCache(JWKSCacheOptions{URL: server.URL, Now: func() time.Time { return now }})
+	verifier := &JWTVerifier{Algorithms: []string{JWTAlgEdDSA}, KeySource: cache}
+
+	token, err := rotator.Sign(StrMap{"uid": "12"})
+	assert.NoError(t, err)
+	_, err = verifier.Parse(token)
+	assert.NoError(t, err)
+	_, err = verifier.Parse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// a new kid refreshes the keys, at most once a minute
+	assert.NoError(t, rotator.Rotate())
+	token, _ = rotator.Sign(StrMap{"uid": "12"})
+	_, err = verifier.Parse(token)
+	assert.True(t, errors.Is(err, ErrJWTKeyNotFound))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	now = now.Add(time.Minute)
+	_, err = verifier.Parse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKSCacheConcurrentRefresh(t *testing.T) {
+	key, _ := GenerateJWTKey(JWTAlgES256)
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		NewJWKSet(key).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	var now atomic.Value
+	now.Store(time.Now())
+	cache := NewJWKSCache(JWKSCacheOptions{URL: server.URL, Now: func() time.Time { return now.Load().(time.Time) }})
+	keys, err := cache.JWTKeys(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// a slow refresh of stale keys doesn't block the other verifications
+	now.Store(now.Load().(time.Time).Add(time.Hour))
+	refreshed := make(chan error)
+	go func() {
+		_, err := cache.JWTKeys(context.Background(), "")
+		refreshed <- err
+	}()
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	keys, err = cache.JWTKeys(context.Background(), key.ID())
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// callers waiting for the refresh in flight give up with their context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, errors.Is(cache.Refresh(ctx), context.Canceled))
+	close(release)
+	assert.NoError(t, <-refreshed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// the first load uses the context of the caller
+	_, err = NewJWKSCache(JWKSCacheOptions{URL: server.URL}).JWTKeys(ctx, "")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestJWKSCacheFile(t *testing.T) {
+	key, _ := GenerateJWTKey(JWTAlgES256)
+	data, _ := json.Marshal(NewJWKSet(key))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	token, _ := MakeJWTTokenWithKey(key, nil)
+	verifier := &JWTVerifier{Algorithms: []string{JWTAlgES256}, KeySource: NewJWKSCache(JWKSCacheOptions{Path: path})}
+	_, err := verifier.Parse(token)
+	assert.NoError(t, err)
+
+	missing := &JWTVerifier{Algorithms: []string{JWTAlgES256}, KeySource: NewJWKSCache(JWKSCacheOptions{Path: path + ".missing"})}
+	_, err = missing.Parse(token)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
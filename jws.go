@@ -0,0 +1,266 @@
+package utility
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the hashes of the algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+)
+
+const (
+	jwtHeaderType     = "typ"
+	jwtHeaderCritical = "crit"
+	jwtTypeJWT        = "JWT"
+)
+
+// jwsEncoding is base64url without padding (RFC 7515 2), strict so that a token has a single
+// encoding.
+var jwsEncoding = base64.RawURLEncoding.Strict()
+
+// jwsAlgorithm signs and verifies the signing input of a JWS for an algorithm (RFC 7518 3),
+// with the sign and verify keys of a JWTKey.
+type jwsAlgorithm interface {
+	sign(key interface{}, signingInput []byte) ([]byte, error)
+	verify(key interface{}, signingInput, signature []byte) error
+}
+
+var jwsAlgorithms = map[string]jwsAlgorithm{
+	JWTAlgHS256: jwsHMAC{crypto.SHA256},
+	JWTAlgHS384: jwsHMAC{crypto.SHA384},
+	JWTAlgHS512: jwsHMAC{crypto.SHA512},
+	JWTAlgRS256: jwsRSA{crypto.SHA256},
+	JWTAlgRS384: jwsRSA{crypto.SHA384},
+	JWTAlgRS512: jwsRSA{crypto.SHA512},
+	JWTAlgPS256: jwsRSAPSS{crypto.SHA256},
+	JWTAlgPS384: jwsRSAPSS{crypto.SHA384},
+	JWTAlgPS512: jwsRSAPSS{crypto.SHA512},
+	JWTAlgES256: jwsECDSA{crypto.SHA256},
+	JWTAlgES384: jwsECDSA{crypto.SHA384},
+	JWTAlgES512: jwsECDSA{crypto.SHA512},
+	JWTAlgEdDSA: jwsEdDSA{},
+}
+
+// jwsToken is a decoded JWS compact serialization (RFC 7515 7.1).
+type jwsToken struct {
+	header       StrMap
+	payload      StrMap
+	signingInput []byte
+	signature    []byte
+}
+
+// encodeJWS signs payload with key, the header is typ JWT, alg and kid.
+func encodeJWS(key *JWTKey, payload StrMap) (string, error) {
+	algorithm, ok := jwsAlgorithms[key.alg]
+	if !ok || !key.CanSign() {
+		return "", ErrJWTKeyInvalid.WithFields(StrMap{"alg": key.alg, "reason": "verification key"})
+	}
+	header := StrMap{jwtHeaderAlgorithm: key.alg, jwtHeaderType: jwtTypeJWT}
+	if key.id != "" {
+		header[jwtHeaderKeyID] = key.id
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	if payload == nil {
+		payload = StrMap{}
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwsEncoding.EncodeToString(headerJSON) + "." + jwsEncoding.EncodeToString(payloadJSON)
+	signature, err := algorithm.sign(key.signKey, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwsEncoding.EncodeToString(signature), nil
+}
+
+// decodeJWS decodes a token without verifying it. The payload must be a JSON object, as JWT
+// claims, and critical header parameters are rejected as none is supported (RFC 7515 4.1.11).
+func decodeJWS(tokenStr string) (*jwsToken, error) {
+	parts := strings.Split(tokenStr, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed.WithField("error", "token has not 3 parts")
+	}
+	var segments [3][]byte
+	for i, part := range parts {
+		var err error
+		if segments[i], err = jwsEncoding.DecodeString(part); err != nil {
+			return nil, ErrJWTMalformed.WithField("error", err.Error())
+		}
+	}
+	token := &jwsToken{
+		signingInput: []byte(tokenStr[:len(parts[0])+1+len(parts[1])]),
+		signature:    segments[2],
+	}
+	if err := json.Unmarshal(segments[0], &token.header); err != nil || token.header == nil {
+		return nil, ErrJWTMalformed.WithField("error", "header is not a JSON object")
+	}
+	if _, ok := token.header[jwtHeaderAlgorithm].(string); !ok {
+		return nil, ErrJWTMalformed.WithField("error", "no alg header")
+	}
+	if _, ok := token.header[jwtHeaderCritical]; ok {
+		return nil, ErrJWTMalformed.WithField("error", "unsupported crit header")
+	}
+	if err := json.Unmarshal(segments[1], &token.payload); err != nil || token.payload == nil {
+		return nil, ErrJWTMalformed.WithField("error", "payload is not a JSON object")
+	}
+	return token, nil
+}
+
+func jwsDigest(hash crypto.Hash, signingInput []byte) []byte {
+	h := hash.New()
+	h.Write(signingInput)
+	return h.Sum(nil)
+}
+
+func jwsKeyTypeError() error {
+	return ErrJWTKeyInvalid.WithField("reason", "key type")
+}
+
+type jwsHMAC struct {
+	hash crypto.Hash
+}
+
+func (a jwsHMAC) sign(key interface{}, signingInput []byte) ([]byte, error) {
+	secret, ok := key.([]byte)
+	if !ok {
+		return nil, jwsKeyTypeError()
+	}
+	mac := hmac.New(a.hash.New, secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
+
+func (a jwsHMAC) verify(key interface{}, signingInput, signature []byte) error {
+	expected, err := a.sign(key, signingInput)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, signature) {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
+
+// jwsRSA is RSASSA-PKCS1-v1_5.
+type jwsRSA struct {
+	hash crypto.Hash
+}
+
+func (a jwsRSA) sign(key interface{}, signingInput []byte) ([]byte, error) {
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, jwsKeyTypeError()
+	}
+	return rsa.SignPKCS1v15(rand.Reader, privateKey, a.hash, jwsDigest(a.hash, signingInput))
+}
+
+func (a jwsRSA) verify(key interface{}, signingInput, signature []byte) error {
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return jwsKeyTypeError()
+	}
+	if rsa.VerifyPKCS1v15(publicKey, a.hash, jwsDigest(a.hash, signingInput), signature) != nil {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
+
+// jwsRSAPSS is RSASSA-PSS with MGF1 of the same hash.
+type jwsRSAPSS struct {
+	hash crypto.Hash
+}
+
+func (a jwsRSAPSS) sign(key interface{}, signingInput []byte) ([]byte, error) {
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, jwsKeyTypeError()
+	}
+	// the salt is the size of the hash (RFC 7518 3.5)
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: a.hash}
+	return rsa.SignPSS(rand.Reader, privateKey, a.hash, jwsDigest(a.hash, signingInput), opts)
+}
+
+func (a jwsRSAPSS) verify(key interface{}, signingInput, signature []byte) error {
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return jwsKeyTypeError()
+	}
+	// any salt size, as jwt-go signed with the largest one
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: a.hash}
+	if rsa.VerifyPSS(publicKey, a.hash, jwsDigest(a.hash, signingInput), signature, opts) != nil {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
+
+// jwsECDSA signatures are R and S of the size of the curve, concatenated (RFC 7518 3.4).
+type jwsECDSA struct {
+	hash crypto.Hash
+}
+
+func (a jwsECDSA) sign(key interface{}, signingInput []byte) ([]byte, error) {
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, jwsKeyTypeError()
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, jwsDigest(a.hash, signingInput))
+	if err != nil {
+		return nil, err
+	}
+	size := ecdsaKeySize(privateKey.Curve)
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
+
+func (a jwsECDSA) verify(key interface{}, signingInput, signature []byte) error {
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return jwsKeyTypeError()
+	}
+	size := ecdsaKeySize(publicKey.Curve)
+	if len(signature) != 2*size {
+		return ErrJWTSignatureInvalid
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(publicKey, jwsDigest(a.hash, signingInput), r, s) {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
+
+// jwsEdDSA is EdDSA with Ed25519 (RFC 8037 3.1).
+type jwsEdDSA struct{}
+
+func (jwsEdDSA) sign(key interface{}, signingInput []byte) ([]byte, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return nil, jwsKeyTypeError()
+	}
+	return ed25519.Sign(privateKey, signingInput), nil
+}
+
+func (jwsEdDSA) verify(key interface{}, signingInput, signature []byte) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwsKeyTypeError()
+	}
+	if !ed25519.Verify(publicKey, signingInput, signature) {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
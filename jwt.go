@@ -1,51 +1,154 @@
 package utility
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
-	"time"
+)
+
+const (
+	ErrorCodeJWTSignatureInvalid ErrorCode = "jwt_signature_invalid"
 
-	"github.com/dgrijalva/jwt-go"
+	jwtHeaderAlgorithm = "alg"
+	jwtHeaderKeyID     = "kid"
 )
 
 var (
-	ErrJWTAlgInvalid = errors.New("jwt_alg_invalid")
+	ErrJWTAlgInvalid       = errors.New("jwt_alg_invalid")
+	ErrJWTSignatureInvalid = newJWTError("invalid signature", ErrorCodeJWTSignatureInvalid)
 )
 
+// MakeJWTToken signs payload with iat set to now, in seconds. key is the secret of HS algorithms,
+// a PEM private key for the others, see ParseJWTKeyPEM. See JWTBuilder for the other registered claims.
 func MakeJWTToken(alg string, key []byte, payload StrMap) (string, error) {
-	claims := jwt.MapClaims{}
-	for k, v := range payload {
-		claims[k] = v
-	}
-	claims["iat"] = time.Now().Unix() * 1000
-	method := jwt.GetSigningMethod(normalizedJWTAlg(alg))
-	token := jwt.NewWithClaims(method, claims)
-	return token.SignedString(key)
+	return NewJWTBuilder().Claims(payload).Sign(alg, key)
+}
+
+// MakeJWTTokenWithKey signs payload with key, see MakeJWTToken. The kid header is set to the
+// ID of key if any.
+func MakeJWTTokenWithKey(key *JWTKey, payload StrMap) (string, error) {
+	return NewJWTBuilder().Claims(payload).SignWithKey(key)
 }
 
+// ParseJWTPayload verifies the token, signed with alg only, and its exp and nbf claims.
+// key is the secret of HS algorithms, a PEM public key for the others. See ValidateJWT for the other claims.
 func ParseJWTPayload(tokenStr string, alg string, key []byte) (payload interface{}, err error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		tokenAlg := token.Method.Alg()
-		if tokenAlg != normalizedJWTAlg(alg) {
-			return nil, fmt.Errorf("signing method invalid %w: %v", ErrJWTAlgInvalid, tokenAlg)
-		}
-		return key, nil
-	})
-	if err == nil {
-		switch v := token.Claims.(type) {
-		case jwt.MapClaims:
-			payload = StrMap(v)
-		default:
-			payload = v
-		}
+	k, err := jwtKeyFromBytes(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWTPayloadWithKey(tokenStr, k)
+}
+
+// ParseJWTPayloadWithKey is ParseJWTPayload for a token signed with key.
+func ParseJWTPayloadWithKey(tokenStr string, key *JWTKey) (payload interface{}, err error) {
+	claims, err := (&JWTVerifier{Algorithms: []string{key.Alg()}, Keys: []*JWTKey{key}}).Parse(tokenStr)
+	if err != nil {
+		// a nil interface, not a nil StrMap in it
+		return nil, err
 	}
-	return
+	return claims, nil
+}
+
+// ValidateJWT verifies the token, signed with alg only, then its claims with validator.
+func ValidateJWT(tokenStr string, alg string, key []byte, validator *JWTValidator) (*JWTClaims, error) {
+	k, err := jwtKeyFromBytes(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	verifier := &JWTVerifier{Algorithms: []string{k.Alg()}, Keys: []*JWTKey{k}, Validator: validator}
+	return verifier.Verify(tokenStr)
+}
+
+// JWTKeySource provides verification keys, by kid if not empty. ctx is the one of the
+// verification, e.g. to bound a fetch of the keys.
+type JWTKeySource interface {
+	JWTKeys(ctx context.Context, kid string) ([]*JWTKey, error)
+}
+
+// JWTVerifier verifies tokens signed with one of its keys.
+type JWTVerifier struct {
+	// Algorithms is the allowlist of the alg header, tokens of other algorithms are rejected
+	// whatever the keys. Required.
+	Algorithms []string
+	// Keys are tried in turn among the ones of the token algorithm, and of its kid header if any.
+	Keys []*JWTKey
+	// KeySource provides more keys, e.g. a JWKSCache or a JWTKeyRotator.
+	KeySource JWTKeySource
+	// Validator checks the claims, default exp and nbf only.
+	Validator *JWTValidator
+}
+
+// Parse verifies the token and returns its payload.
+func (v *JWTVerifier) Parse(tokenStr string) (StrMap, error) {
+	return v.ParseContext(context.Background(), tokenStr)
+}
+
+// ParseContext is Parse with the context given to KeySource.
+func (v *JWTVerifier) ParseContext(ctx context.Context, tokenStr string) (StrMap, error) {
+	payload, _, err := v.verify(ctx, tokenStr)
+	return payload, err
+}
+
+// Verify verifies the token and returns its claims.
+func (v *JWTVerifier) Verify(tokenStr string) (*JWTClaims, error) {
+	return v.VerifyContext(context.Background(), tokenStr)
 }
 
-func normalizedJWTAlg(alg string) string {
-	if alg == "" {
-		return "HS256"
+// VerifyContext is Verify with the context given to KeySource.
+func (v *JWTVerifier) VerifyContext(ctx context.Context, tokenStr string) (*JWTClaims, error) {
+	_, claims, err := v.verify(ctx, tokenStr)
+	return claims, err
+}
+
+func (v *JWTVerifier) verify(ctx context.Context, tokenStr string) (StrMap, *JWTClaims, error) {
+	token, err := decodeJWS(tokenStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	alg := token.header[jwtHeaderAlgorithm].(string)
+	algorithm, allowed := jwsAlgorithms[alg]
+	if allowed {
+		allowed = false
+		for _, a := range v.Algorithms {
+			if normalizedJWTAlg(a) == alg {
+				allowed = true
+				break
+			}
+		}
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("signing method invalid %w: %v", ErrJWTAlgInvalid, alg)
+	}
+	kid, _ := token.header[jwtHeaderKeyID].(string)
+	keys := v.Keys
+	if v.KeySource != nil {
+		sourceKeys, err := v.KeySource.JWTKeys(ctx, kid)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(append([]*JWTKey(nil), keys...), sourceKeys...)
+	}
+	err = ErrJWTKeyNotFound.WithFields(StrMap{"alg": alg, "kid": kid})
+	for _, key := range keys {
+		if key.alg != alg || (kid != "" && key.id != "" && key.id != kid) {
+			continue
+		}
+		if err = algorithm.verify(key.verifyKey, token.signingInput, token.signature); err == nil {
+			break
+		}
+		err = ErrJWTSignatureInvalid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	validator := v.Validator
+	if validator == nil {
+		validator = &JWTValidator{}
+	}
+	claims, err := validator.Validate(token.payload)
+	if err != nil {
+		return nil, nil, err
 	}
-	return strings.ToUpper(alg)
+	return token.payload, claims, nil
 }
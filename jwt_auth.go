@@ -0,0 +1,191 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// JWTClaimScope holds space separated scopes (RFC 8693 4.2).
+	JWTClaimScope = "scope"
+
+	jwtAuthScheme = "Bearer"
+)
+
+const (
+	ErrorCodeJWTMissing           ErrorCode = "jwt_missing"
+	ErrorCodeJWTInvalidRequest    ErrorCode = "jwt_invalid_request"
+	ErrorCodeJWTInsufficientScope ErrorCode = "jwt_insufficient_scope"
+)
+
+var (
+	ErrJWTMissing        = newJWTError("missing token", ErrorCodeJWTMissing)
+	ErrJWTInvalidRequest = NewError("token sent more than once").
+				WithCode(ErrorCodeJWTInvalidRequest).
+				WithCategory(ErrorCategoryInvalidArgument)
+	ErrJWTInsufficientScope = NewError("insufficient scope").
+				WithCode(ErrorCodeJWTInsufficientScope).
+				WithCategory(ErrorCategoryForbidden)
+)
+
+type jwtClaimsKey struct{}
+
+// JWTClaimsFromContext returns the claims stored by the JWT auth middleware, nil if none.
+func JWTClaimsFromContext(ctx context.Context) StrMap {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(StrMap)
+	return claims
+}
+
+func ContextWithJWTClaims(ctx context.Context, claims StrMap) context.Context {
+	return context.WithValue(ctx, jwtClaimsKey{}, claims)
+}
+
+type JWTAuthOptions struct {
+	// Verifier verifies the tokens, required.
+	Verifier *JWTVerifier
+	// Revocations rejects the tokens whose jti or family was revoked, see JWTTokenIssuer.
+	Revocations JWTRevocationStore
+	// CookieName and QueryParam name other places of the token, the Authorization header is
+	// always read first. The query is discouraged by RFC 6750 2.3 as URLs are logged.
+	CookieName string
+	QueryParam string
+	// Realm of the WWW-Authenticate challenges.
+	Realm string
+	// RequiredScopes must all be in the scope claim.
+	RequiredScopes []string
+	// Optional lets requests without a token through, without claims.
+	Optional bool
+	// OnError answers rejected requests, default writeJWTAuthError. err is ErrJWTMissing,
+	// ErrJWTInvalidRequest, ErrJWTInsufficientScope, a verification error or an error of Revocations.
+	OnError func(w http.ResponseWriter, req *http.Request, err error)
+}
+
+// NewJWTAuthMiddleware verifies the bearer token of requests and stores its claims in the request
+// context, see JWTClaimsFromContext. Rejected requests are answered with RFC 6750 errors.
+func NewJWTAuthMiddleware(opts JWTAuthOptions) func(http.Handler) http.Handler {
+	if opts.OnError == nil {
+		realm := opts.Realm
+		opts.OnError = func(w http.ResponseWriter, _ *http.Request, err error) {
+			writeJWTAuthError(w, realm, opts.RequiredScopes, err)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			claims, err := authenticateJWT(req, &opts)
+			if errors.Is(err, ErrJWTMissing) && opts.Optional {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if err != nil {
+				opts.OnError(w, req, err)
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(ContextWithJWTClaims(req.Context(), claims)))
+		})
+	}
+}
+
+func authenticateJWT(req *http.Request, opts *JWTAuthOptions) (StrMap, error) {
+	token, err := BearerToken(req, opts.CookieName, opts.QueryParam)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := opts.Verifier.ParseContext(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if use, ok := claims[jwtClaimTokenUse]; ok && use != jwtTokenUseAccess {
+		return nil, ErrJWTInvalidClaim.WithField("claim", jwtClaimTokenUse)
+	}
+	if opts.Revocations != nil {
+		if err := checkJWTRevocation(req.Context(), opts.Revocations, claims); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.RequiredScopes) > 0 {
+		scopes := strings.Fields(AnyToString(claims[JWTClaimScope]))
+		for _, scope := range opts.RequiredScopes {
+			if !containsString(scopes, scope) {
+				return nil, ErrJWTInsufficientScope.WithField("scope", scope)
+			}
+		}
+	}
+	return claims, nil
+}
+
+// BearerToken returns the token of the Authorization header (RFC 6750 2.1), else of the cookie
+// or query parameter if their name isn't empty. A token in both the header and the query is
+// ErrJWTInvalidRequest, no token is ErrJWTMissing.
+func BearerToken(req *http.Request, cookieName, queryParam string) (string, error) {
+	var token string
+	if authorization := req.Header.Get(HTTPHeaderAuthorization); authorization != "" {
+		scheme, credentials, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, jwtAuthScheme) {
+			return "", ErrJWTMissing
+		}
+		token = strings.TrimSpace(credentials)
+	}
+	if queryParam != "" {
+		if query := req.URL.Query().Get(queryParam); query != "" {
+			if token != "" {
+				return "", ErrJWTInvalidRequest
+			}
+			token = query
+		}
+	}
+	if token == "" && cookieName != "" {
+		if cookie, err := req.Cookie(cookieName); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return "", ErrJWTMissing
+	}
+	return token, nil
+}
+
+// writeJWTAuthError answers err with its RFC 6750 3 challenge.
+func writeJWTAuthError(w http.ResponseWriter, realm string, scopes []string, err error) {
+	var status int
+	var params []string
+	if realm != "" {
+		params = append(params, jwtAuthParam("realm", realm))
+	}
+	switch {
+	case errors.Is(err, ErrJWTMissing):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrJWTInvalidRequest):
+		status = http.StatusBadRequest
+		params = append(params, jwtAuthParam("error", "invalid_request"))
+	case errors.Is(err, ErrJWTInsufficientScope):
+		status = http.StatusForbidden
+		params = append(params, jwtAuthParam("error", "insufficient_scope"),
+			jwtAuthParam("scope", strings.Join(scopes, " ")))
+	case errors.Is(err, ErrJWTAlgInvalid) || ErrorCategoryOf(err) == ErrorCategoryUnauthorized:
+		status = http.StatusUnauthorized
+		params = append(params, jwtAuthParam("error", "invalid_token"),
+			jwtAuthParam("error_description", err.Error()))
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	challenge := jwtAuthScheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// jwtAuthParam quotes value, dropping the characters RFC 6750 3 excludes.
+func jwtAuthParam(name, value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, value)
+	return name + `="` + value + `"`
+}
@@ -0,0 +1,82 @@
+package utility
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	key, _ := NewJWTKey(JWTAlgHS256, []byte("secret"))
+	middleware := NewJWTAuthMiddleware(JWTAuthOptions{
+		Verifier:       &JWTVerifier{Algorithms: []string{JWTAlgHS256}, Keys: []*JWTKey{key}},
+		CookieName:     "session",
+		QueryParam:     "access_token",
+		Realm:          "api",
+		RequiredScopes: []string{"read"},
+	})
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(AnyToString(JWTClaimsFromContext(req.Context())["sub"])))
+	}))
+	serve := func(setup func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		setup(req)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	token, _ := NewJWTBuilder().Subject("u1").ExpiresIn(time.Minute).Claim("scope", "read write").SignWithKey(key)
+	w := serve(func(req *http.Request) { req.Header.Set(HTTPHeaderAuthorization, "bearer "+token) })
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "u1", w.Body.String())
+
+	w = serve(func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "session", Value: token}) })
+	assert.Equal(t, "u1", w.Body.String())
+	w = serve(func(req *http.Request) { req.URL.RawQuery = "access_token=" + token })
+	assert.Equal(t, "u1", w.Body.String())
+
+	w = serve(func(req *http.Request) {})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="api"`, w.Header().Get("WWW-Authenticate"))
+
+	w = serve(func(req *http.Request) {
+		req.Header.Set(HTTPHeaderAuthorization, "Bearer "+token)
+		req.URL.RawQuery = "access_token=" + token
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `Bearer realm="api", error="invalid_request"`, w.Header().Get("WWW-Authenticate"))
+
+	expired, _ := NewJWTBuilder().IssuedAt(time.Now().Add(-time.Hour)).ExpiresIn(time.Minute).SignWithKey(key)
+	w = serve(func(req *http.Request) { req.Header.Set(HTTPHeaderAuthorization, "Bearer "+expired) })
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="token is expired"`,
+		w.Header().Get("WWW-Authenticate"))
+
+	readOnly, _ := NewJWTBuilder().Claim("scope", "write").SignWithKey(key)
+	w = serve(func(req *http.Request) { req.Header.Set(HTTPHeaderAuthorization, "Bearer "+readOnly) })
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer realm="api", error="insufficient_scope", scope="read"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestJWTAuthMiddlewareOptional(t *testing.T) {
+	key, _ := NewJWTKey(JWTAlgHS256, []byte("secret"))
+	handler := NewJWTAuthMiddleware(JWTAuthOptions{
+		Verifier: &JWTVerifier{Algorithms: []string{JWTAlgHS256}, Keys: []*JWTKey{key}},
+		Optional: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Nil(t, JWTClaimsFromContext(req.Context()))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HTTPHeaderAuthorization, "Bearer invalid")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
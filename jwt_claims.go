@@ -0,0 +1,399 @@
+package utility
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Registered claim names (RFC 7519 4.1).
+const (
+	JWTClaimIssuer    = "iss"
+	JWTClaimSubject   = "sub"
+	JWTClaimAudience  = "aud"
+	JWTClaimExpiresAt = "exp"
+	JWTClaimNotBefore = "nbf"
+	JWTClaimIssuedAt  = "iat"
+	JWTClaimID        = "jti"
+
+	// jwtIDRandomLength random bytes make a jti, 128 bits as a UUID v4
+	jwtIDRandomLength = 16
+)
+
+const (
+	ErrorCodeJWTMalformed       ErrorCode = "jwt_malformed"
+	ErrorCodeJWTInvalidClaim    ErrorCode = "jwt_invalid_claim"
+	ErrorCodeJWTMissingClaim    ErrorCode = "jwt_missing_claim"
+	ErrorCodeJWTExpired         ErrorCode = "jwt_expired"
+	ErrorCodeJWTNotValidYet     ErrorCode = "jwt_not_valid_yet"
+	ErrorCodeJWTInvalidIssuer   ErrorCode = "jwt_invalid_issuer"
+	ErrorCodeJWTInvalidAudience ErrorCode = "jwt_invalid_audience"
+	ErrorCodeJWTInvalidSubject  ErrorCode = "jwt_invalid_subject"
+)
+
+var (
+	ErrJWTMalformed       = newJWTError("malformed token", ErrorCodeJWTMalformed)
+	ErrJWTInvalidClaim    = newJWTError("invalid claim", ErrorCodeJWTInvalidClaim)
+	ErrJWTMissingClaim    = newJWTError("missing required claim", ErrorCodeJWTMissingClaim)
+	ErrJWTExpired         = newJWTError("token is expired", ErrorCodeJWTExpired)
+	ErrJWTNotValidYet     = newJWTError("token is not valid yet", ErrorCodeJWTNotValidYet)
+	ErrJWTInvalidIssuer   = newJWTError("invalid issuer", ErrorCodeJWTInvalidIssuer)
+	ErrJWTInvalidAudience = newJWTError("invalid audience", ErrorCodeJWTInvalidAudience)
+	ErrJWTInvalidSubject  = newJWTError("invalid subject", ErrorCodeJWTInvalidSubject)
+)
+
+func newJWTError(message string, code ErrorCode) *Error {
+	return NewError(message).WithCode(code).WithCategory(ErrorCategoryUnauthorized)
+}
+
+// JWTClaims are the registered claims of a token, zero values are absent claims.
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// Custom holds the other claims.
+	Custom StrMap
+}
+
+// ParseJWTClaims reads the claims of a payload. Dates are NumericDate seconds (RFC 7519 2),
+// or milliseconds if millis, for tokens of the legacy millisecond mode.
+func ParseJWTClaims(payload StrMap, millis bool) (*JWTClaims, error) {
+	claims := &JWTClaims{Custom: StrMap{}}
+	var err error
+	for key, value := range payload {
+		switch key {
+		case JWTClaimIssuer:
+			claims.Issuer, err = jwtStringClaim(key, value)
+		case JWTClaimSubject:
+			claims.Subject, err = jwtStringClaim(key, value)
+		case JWTClaimID:
+			claims.ID, err = jwtStringClaim(key, value)
+		case JWTClaimAudience:
+			claims.Audience, err = jwtAudienceClaim(value)
+		case JWTClaimExpiresAt:
+			claims.ExpiresAt, err = jwtDateClaim(key, value, millis)
+		case JWTClaimNotBefore:
+			claims.NotBefore, err = jwtDateClaim(key, value, millis)
+		case JWTClaimIssuedAt:
+			claims.IssuedAt, err = jwtDateClaim(key, value, millis)
+		default:
+			claims.Custom[key] = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// StrMap returns the claims as a payload, dates in seconds, or milliseconds if millis.
+// A single audience is encoded as a string.
+func (c *JWTClaims) StrMap(millis bool) StrMap {
+	payload := StrMap{}
+	for key, value := range c.Custom {
+		payload[key] = value
+	}
+	setString := func(key, value string) {
+		if value != "" {
+			payload[key] = value
+		}
+	}
+	setDate := func(key string, t time.Time) {
+		if t.IsZero() {
+			return
+		}
+		if millis {
+			payload[key] = t.UnixNano() / int64(time.Millisecond)
+		} else {
+			payload[key] = t.Unix()
+		}
+	}
+	setString(JWTClaimIssuer, c.Issuer)
+	setString(JWTClaimSubject, c.Subject)
+	setString(JWTClaimID, c.ID)
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		payload[JWTClaimAudience] = c.Audience[0]
+	default:
+		payload[JWTClaimAudience] = append([]string(nil), c.Audience...)
+	}
+	setDate(JWTClaimExpiresAt, c.ExpiresAt)
+	setDate(JWTClaimNotBefore, c.NotBefore)
+	setDate(JWTClaimIssuedAt, c.IssuedAt)
+	return payload
+}
+
+func isJWTRegisteredClaim(key string) bool {
+	switch key {
+	case JWTClaimIssuer, JWTClaimSubject, JWTClaimAudience, JWTClaimExpiresAt, JWTClaimNotBefore, JWTClaimIssuedAt, JWTClaimID:
+		return true
+	}
+	return false
+}
+
+func jwtStringClaim(key string, value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", ErrJWTInvalidClaim.WithField("claim", key)
+	}
+	return s, nil
+}
+
+// jwtAudienceClaim accepts a string or an array of strings (RFC 7519 4.1.3).
+func jwtAudienceClaim(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		audience := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, ErrJWTInvalidClaim.WithField("claim", JWTClaimAudience)
+			}
+			audience = append(audience, s)
+		}
+		return audience, nil
+	}
+	return nil, ErrJWTInvalidClaim.WithField("claim", JWTClaimAudience)
+}
+
+func jwtDateClaim(key string, value interface{}, millis bool) (time.Time, error) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int64:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case json.Number:
+		var err error
+		if f, err = v.Float64(); err != nil {
+			return time.Time{}, ErrJWTInvalidClaim.WithField("claim", key)
+		}
+	default:
+		return time.Time{}, ErrJWTInvalidClaim.WithField("claim", key)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, ErrJWTInvalidClaim.WithField("claim", key)
+	}
+	if millis {
+		return time.UnixMilli(int64(f)), nil
+	}
+	seconds, fraction := math.Modf(f)
+	return time.Unix(int64(seconds), int64(fraction*1e9)), nil
+}
+
+// JWTBuilder builds the claims of a token.
+type JWTBuilder struct {
+	claims     JWTClaims
+	ttl        time.Duration
+	generateID bool
+	millis     bool
+	now        func() time.Time
+}
+
+func NewJWTBuilder() *JWTBuilder {
+	return &JWTBuilder{claims: JWTClaims{Custom: StrMap{}}, now: time.Now}
+}
+
+func (b *JWTBuilder) Issuer(issuer string) *JWTBuilder {
+	b.claims.Issuer = issuer
+	return b
+}
+
+func (b *JWTBuilder) Subject(subject string) *JWTBuilder {
+	b.claims.Subject = subject
+	return b
+}
+
+func (b *JWTBuilder) Audience(audience ...string) *JWTBuilder {
+	b.claims.Audience = audience
+	return b
+}
+
+// ExpiresIn sets exp to ttl after iat.
+func (b *JWTBuilder) ExpiresIn(ttl time.Duration) *JWTBuilder {
+	b.ttl = ttl
+	return b
+}
+
+func (b *JWTBuilder) ExpiresAt(t time.Time) *JWTBuilder {
+	b.claims.ExpiresAt = t
+	b.ttl = 0
+	return b
+}
+
+func (b *JWTBuilder) NotBefore(t time.Time) *JWTBuilder {
+	b.claims.NotBefore = t
+	return b
+}
+
+// IssuedAt overrides iat, which defaults to now.
+func (b *JWTBuilder) IssuedAt(t time.Time) *JWTBuilder {
+	b.claims.IssuedAt = t
+	return b
+}
+
+func (b *JWTBuilder) ID(id string) *JWTBuilder {
+	b.claims.ID = id
+	b.generateID = false
+	return b
+}
+
+// GenerateID sets jti to a new random ID from crypto/rand, see Payload.
+func (b *JWTBuilder) GenerateID() *JWTBuilder {
+	b.generateID = true
+	return b
+}
+
+// Claim sets a custom claim, registered claims are set through their methods.
+func (b *JWTBuilder) Claim(key string, value interface{}) *JWTBuilder {
+	b.claims.Custom[key] = value
+	return b
+}
+
+func (b *JWTBuilder) Claims(claims StrMap) *JWTBuilder {
+	for key, value := range claims {
+		b.claims.Custom[key] = value
+	}
+	return b
+}
+
+// MillisecondTimestamps writes dates in milliseconds, as MakeJWTToken used to. Only for consumers
+// that can't be migrated: it isn't RFC 7519 NumericDate and other libraries misread it.
+func (b *JWTBuilder) MillisecondTimestamps() *JWTBuilder {
+	b.millis = true
+	return b
+}
+
+// Payload returns the payload to sign. It panics if GenerateID is set and crypto/rand fails,
+// SignWithKey returns the error instead.
+func (b *JWTBuilder) Payload() StrMap {
+	payload, err := b.payload()
+	if err != nil {
+		panic(err)
+	}
+	return payload
+}
+
+func (b *JWTBuilder) payload() (StrMap, error) {
+	claims := b.claims
+	if claims.IssuedAt.IsZero() {
+		claims.IssuedAt = b.now()
+	}
+	if b.ttl > 0 {
+		claims.ExpiresAt = claims.IssuedAt.Add(b.ttl)
+	}
+	if b.generateID {
+		var err error
+		if claims.ID, err = newJWTID(); err != nil {
+			return nil, err
+		}
+	}
+	return claims.StrMap(b.millis), nil
+}
+
+// newJWTID returns a random ID for revocations, unguessable as it is from crypto/rand.
+func newJWTID() (string, error) {
+	id, err := randomBytes(jwtIDRandomLength)
+	if err != nil {
+		return "", err
+	}
+	return jwsEncoding.EncodeToString(id), nil
+}
+
+// Sign signs the payload with alg, key is read as by MakeJWTToken.
+func (b *JWTBuilder) Sign(alg string, key []byte) (string, error) {
+	k, err := jwtKeyFromBytes(alg, key)
+	if err != nil {
+		return "", err
+	}
+	return b.SignWithKey(k)
+}
+
+func (b *JWTBuilder) SignWithKey(key *JWTKey) (string, error) {
+	payload, err := b.payload()
+	if err != nil {
+		return "", err
+	}
+	return encodeJWS(key, payload)
+}
+
+// JWTValidator validates the registered claims of a payload.
+type JWTValidator struct {
+	// Issuers are the accepted iss, any if empty.
+	Issuers []string
+	// Audiences identify the recipient, the aud of tokens must contain one of them.
+	// The aud claim isn't checked if empty.
+	Audiences []string
+	// Subject is the required sub if not empty.
+	Subject string
+	// ClockSkew tolerates clocks differences on exp and nbf.
+	ClockSkew time.Duration
+	// RequiredClaims must be present, e.g. "exp".
+	RequiredClaims []string
+	// MillisecondTimestamps reads dates of tokens of the legacy millisecond mode.
+	MillisecondTimestamps bool
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Validate checks the claims of payload and returns them.
+func (v *JWTValidator) Validate(payload StrMap) (*JWTClaims, error) {
+	claims, err := ParseJWTClaims(payload, v.MillisecondTimestamps)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range v.RequiredClaims {
+		if _, ok := payload[key]; !ok {
+			return nil, ErrJWTMissingClaim.WithField("claim", key)
+		}
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(v.ClockSkew)) {
+		return nil, ErrJWTExpired.WithField("exp", claims.ExpiresAt)
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.ClockSkew).Before(claims.NotBefore) {
+		return nil, ErrJWTNotValidYet.WithField("nbf", claims.NotBefore)
+	}
+	if len(v.Issuers) > 0 && !containsString(v.Issuers, claims.Issuer) {
+		return nil, ErrJWTInvalidIssuer.WithField("iss", claims.Issuer)
+	}
+	if v.Subject != "" && claims.Subject != v.Subject {
+		return nil, ErrJWTInvalidSubject.WithField("sub", claims.Subject)
+	}
+	if len(v.Audiences) > 0 {
+		matched := false
+		for _, audience := range claims.Audience {
+			if containsString(v.Audiences, audience) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, ErrJWTInvalidAudience.WithField("aud", claims.Audience)
+		}
+	}
+	return claims, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package utility
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+)
+
+// JWT signature algorithms (RFC 7518 3.1, RFC 8037 3.1).
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgHS384 = "HS384"
+	JWTAlgHS512 = "HS512"
+	JWTAlgRS256 = "RS256"
+	JWTAlgRS384 = "RS384"
+	JWTAlgRS512 = "RS512"
+	JWTAlgPS256 = "PS256"
+	JWTAlgPS384 = "PS384"
+	JWTAlgPS512 = "PS512"
+	JWTAlgES256 = "ES256"
+	JWTAlgES384 = "ES384"
+	JWTAlgES512 = "ES512"
+	JWTAlgEdDSA = "EdDSA"
+
+	// minJWTRSAKeyBits is the minimum of RFC 7518 3.3.
+	minJWTRSAKeyBits = 2048
+)
+
+const (
+	ErrorCodeJWTKeyInvalid  ErrorCode = "jwt_key_invalid"
+	ErrorCodeJWTKeyNotFound ErrorCode = "jwt_key_not_found"
+)
+
+var (
+	ErrJWTKeyInvalid = NewError("invalid key").
+				WithCode(ErrorCodeJWTKeyInvalid).
+				WithCategory(ErrorCategoryInvalidArgument)
+	ErrJWTKeyNotFound = newJWTError("no key for token", ErrorCodeJWTKeyNotFound)
+)
+
+// JWTKey is a key bound to a signature algorithm: it only signs and verifies tokens of it, so a
+// public key can't be used as an HMAC secret.
+type JWTKey struct {
+	alg string
+	id  string
+	// signKey is nil for verification keys.
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewJWTKey binds key to alg. Keys are []byte secrets for HS algorithms, *rsa.PrivateKey or
+// *rsa.PublicKey of 2048 bits or more for RS and PS ones, *ecdsa.PrivateKey or *ecdsa.PublicKey
+// of the curve of ES ones, ed25519.PrivateKey or ed25519.PublicKey for EdDSA.
+// Public keys only verify tokens.
+func NewJWTKey(alg string, key interface{}) (*JWTKey, error) {
+	alg = normalizedJWTAlg(alg)
+	invalid := func(reason string) (*JWTKey, error) {
+		return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": reason})
+	}
+	family := jwtAlgFamily(alg)
+	if family == "" {
+		return invalid("unsupported algorithm")
+	}
+	k := &JWTKey{alg: alg}
+	switch v := key.(type) {
+	case []byte:
+		if family != "HS" || len(v) == 0 {
+			return invalid("secret key")
+		}
+		k.signKey, k.verifyKey = v, v
+	case *rsa.PrivateKey:
+		k.signKey, k.verifyKey = v, &v.PublicKey
+	case *rsa.PublicKey:
+		k.verifyKey = v
+	case *ecdsa.PrivateKey:
+		k.signKey, k.verifyKey = v, &v.PublicKey
+	case *ecdsa.PublicKey:
+		k.verifyKey = v
+	case ed25519.PrivateKey:
+		if len(v) != ed25519.PrivateKeySize {
+			return invalid("ed25519 key size")
+		}
+		k.signKey, k.verifyKey = v, v.Public()
+	case ed25519.PublicKey:
+		if len(v) != ed25519.PublicKeySize {
+			return invalid("ed25519 key size")
+		}
+		k.verifyKey = v
+	default:
+		return invalid("unsupported key type")
+	}
+	switch public := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		if family != "RS" && family != "PS" {
+			return invalid("rsa key")
+		}
+		if public.N.BitLen() < minJWTRSAKeyBits {
+			return invalid("rsa key size")
+		}
+	case *ecdsa.PublicKey:
+		if family != "ES" || public.Curve != jwtAlgCurve(alg) {
+			return invalid("ecdsa key curve")
+		}
+	case ed25519.PublicKey:
+		if family != "EdDSA" {
+			return invalid("ed25519 key")
+		}
+	}
+	return k, nil
+}
+
+// ParseJWTKeyPEM reads the first PEM block of data: a PKCS #1, PKCS #8 or SEC 1 private key,
+// a PKIX or PKCS #1 public key, or a certificate.
+func ParseJWTKeyPEM(alg string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": "no PEM block"})
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": err.Error()})
+		}
+		return NewJWTKey(alg, cert.PublicKey)
+	}
+	return ParseJWTKeyDER(alg, block.Bytes)
+}
+
+// ParseJWTKeyDER reads a DER encoded key, see ParseJWTKeyPEM.
+func ParseJWTKeyDER(alg string, der []byte) (*JWTKey, error) {
+	key, err := parseDERKey(der)
+	if err != nil {
+		return nil, ErrJWTKeyInvalid.WithFields(StrMap{"alg": alg, "reason": err.Error()})
+	}
+	return NewJWTKey(alg, key)
+}
+
+// parseDERKey tries the encodings in turn, as PEM block types aren't reliable: e.g.
+// crypto.NewRSAKeys writes PKIX keys in "RSA PUBLIC KEY" blocks.
+func parseDERKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// jwtKeyFromBytes reads the []byte keys of MakeJWTToken and ParseJWTPayload: secrets for HS
+// algorithms, PEM keys for the others.
+func jwtKeyFromBytes(alg string, key []byte) (*JWTKey, error) {
+	if jwtAlgFamily(normalizedJWTAlg(alg)) == "HS" {
+		return NewJWTKey(alg, key)
+	}
+	return ParseJWTKeyPEM(alg, key)
+}
+
+func (k *JWTKey) Alg() string {
+	return k.alg
+}
+
+// ID is the kid header of the tokens signed with k, see WithID.
+func (k *JWTKey) ID() string {
+	return k.id
+}
+
+// WithID returns a copy of k identified by kid.
+func (k *JWTKey) WithID(kid string) *JWTKey {
+	c := *k
+	c.id = kid
+	return &c
+}
+
+// CanSign reports whether k holds a secret or private key.
+func (k *JWTKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// Public returns k without its private key, the secret of HS keys is kept.
+func (k *JWTKey) Public() *JWTKey {
+	if jwtAlgFamily(k.alg) == "HS" {
+		return k
+	}
+	return &JWTKey{alg: k.alg, id: k.id, verifyKey: k.verifyKey}
+}
+
+// jwtAlgFamily returns HS, RS, PS, ES or EdDSA, "" for unsupported algorithms such as "none".
+func jwtAlgFamily(alg string) string {
+	switch alg {
+	case JWTAlgHS256, JWTAlgHS384, JWTAlgHS512:
+		return "HS"
+	case JWTAlgRS256, JWTAlgRS384, JWTAlgRS512:
+		return "RS"
+	case JWTAlgPS256, JWTAlgPS384, JWTAlgPS512:
+		return "PS"
+	case JWTAlgES256, JWTAlgES384, JWTAlgES512:
+		return "ES"
+	case JWTAlgEdDSA:
+		return JWTAlgEdDSA
+	}
+	return ""
+}
+
+func jwtAlgCurve(alg string) elliptic.Curve {
+	switch alg {
+	case JWTAlgES256:
+		return elliptic.P256()
+	case JWTAlgES384:
+		return elliptic.P384()
+	case JWTAlgES512:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+func normalizedJWTAlg(alg string) string {
+	if alg == "" {
+		return JWTAlgHS256
+	}
+	if strings.EqualFold(alg, JWTAlgEdDSA) {
+		return JWTAlgEdDSA
+	}
+	return strings.ToUpper(alg)
+}
@@ -0,0 +1,121 @@
+package utility
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/kyl2016/utility/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTAsymmetricAlgorithms(t *testing.T) {
+	publicPEM, privatePEM, err := crypto.NewRSAKeys(2048)
+	assert.NoError(t, err)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecPEM := func(key *ecdsa.PrivateKey) ([]byte, []byte) {
+		private, _ := x509.MarshalECPrivateKey(key)
+		public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: private}),
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+	}
+	edPrivate, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPublic, _ := x509.MarshalPKIXPublicKey(edKey.Public())
+
+	keys := map[string][2][]byte{}
+	for _, alg := range []string{JWTAlgRS256, JWTAlgRS384, JWTAlgRS512, JWTAlgPS256} {
+		keys[alg] = [2][]byte{privatePEM, publicPEM}
+	}
+	for alg, key := range map[string]*ecdsa.PrivateKey{JWTAlgES256: p256, JWTAlgES384: p384, JWTAlgES512: p521} {
+		private, public := ecPEM(key)
+		keys[alg] = [2][]byte{private, public}
+	}
+	keys[JWTAlgEdDSA] = [2][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edPrivate}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPublic}),
+	}
+
+	for alg, pair := range keys {
+		token, err := MakeJWTToken(alg, pair[0], StrMap{"uid": "12"})
+		assert.NoError(t, err, alg)
+		payload, err := ParseJWTPayload(token, alg, pair[1])
+		assert.NoError(t, err, alg)
+		assert.Equal(t, "12", payload.(StrMap)["uid"], alg)
+
+		_, err = ParseJWTPayload(token[:len(token)-4]+"AAAA", alg, pair[1])
+		assert.Error(t, err, alg)
+	}
+
+	// an RS256 token isn't accepted by an ES256 key
+	token, _ := MakeJWTToken(JWTAlgRS256, privatePEM, nil)
+	_, err = ParseJWTPayload(token, JWTAlgES256, keys[JWTAlgES256][1])
+	assert.True(t, errors.Is(err, ErrJWTAlgInvalid))
+}
+
+func TestJWTAlgConfusion(t *testing.T) {
+	publicPEM, privatePEM, err := crypto.NewRSAKeys(2048)
+	assert.NoError(t, err)
+	rsaKey, err := ParseJWTKeyPEM(JWTAlgRS256, publicPEM)
+	assert.NoError(t, err)
+	assert.False(t, rsaKey.CanSign())
+
+	// HS256 signed with the public key as secret
+	forged, err := MakeJWTToken(JWTAlgHS256, publicPEM, StrMap{"admin": true})
+	assert.NoError(t, err)
+	_, err = ParseJWTPayloadWithKey(forged, rsaKey)
+	assert.True(t, errors.Is(err, ErrJWTAlgInvalid))
+
+	verifier := &JWTVerifier{Algorithms: []string{JWTAlgRS256, JWTAlgHS256}, Keys: []*JWTKey{rsaKey}}
+	_, err = verifier.Parse(forged)
+	assert.True(t, errors.Is(err, ErrJWTKeyNotFound))
+
+	unsigned := "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0.eyJhZG1pbiI6dHJ1ZX0."
+	_, err = verifier.Parse(unsigned)
+	assert.True(t, errors.Is(err, ErrJWTAlgInvalid))
+
+	_, err = MakeJWTTokenWithKey(rsaKey, nil)
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+
+	privateKey, err := ParseJWTKeyPEM(JWTAlgPS256, privatePEM)
+	assert.NoError(t, err)
+	token, err := MakeJWTTokenWithKey(privateKey, StrMap{"uid": 1})
+	assert.NoError(t, err)
+	_, err = ParseJWTPayloadWithKey(token, privateKey.Public())
+	assert.NoError(t, err)
+}
+
+func TestNewJWTKey(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err := NewJWTKey(JWTAlgES384, p256)
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = NewJWTKey(JWTAlgHS256, p256)
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = NewJWTKey("none", []byte("secret"))
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = NewJWTKey(JWTAlgRS256, []byte("secret"))
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+
+	_, weak, _ := crypto.NewRSAKeys(1024)
+	_, err = ParseJWTKeyPEM(JWTAlgRS256, weak)
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+	_, err = ParseJWTKeyPEM(JWTAlgRS256, []byte("not a key"))
+	assert.True(t, errors.Is(err, ErrJWTKeyInvalid))
+
+	der, _ := x509.MarshalPKIXPublicKey(&p256.PublicKey)
+	key, err := ParseJWTKeyDER("es256", der)
+	assert.NoError(t, err)
+	assert.Equal(t, JWTAlgES256, key.Alg())
+	key, err = NewJWTKey("eddsa", ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	assert.NoError(t, err)
+	assert.Equal(t, JWTAlgEdDSA, key.Alg())
+	assert.True(t, key.CanSign())
+}
@@ -0,0 +1,275 @@
+package utility
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	jwtClaimTokenUse   = "token_use"
+	jwtClaimFamilyID   = "fid"
+	jwtTokenUseAccess  = "access"
+	jwtTokenUseRefresh = "refresh"
+)
+
+const (
+	ErrorCodeJWTRevoked            ErrorCode = "jwt_revoked"
+	ErrorCodeJWTRefreshTokenReused ErrorCode = "jwt_refresh_token_reused"
+)
+
+var (
+	ErrJWTRevoked = newJWTError("token is revoked", ErrorCodeJWTRevoked)
+	// ErrJWTRefreshTokenReused means a refresh token was used twice, so it leaked: the tokens of
+	// its family are revoked.
+	ErrJWTRefreshTokenReused = newJWTError("refresh token reused", ErrorCodeJWTRefreshTokenReused)
+)
+
+// JWTRevocationStore records revoked token IDs until the tokens expire.
+type JWTRevocationStore interface {
+	// Revoke revokes id until the given time, it reports atomically whether id was already
+	// revoked, which detects the concurrent reuse of refresh tokens.
+	Revoke(ctx context.Context, id string, until time.Time) (alreadyRevoked bool, err error)
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// MemoryJWTRevocationStore is a JWTRevocationStore of a single process.
+type MemoryJWTRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	now     func() time.Time
+}
+
+func NewMemoryJWTRevocationStore() *MemoryJWTRevocationStore {
+	return &MemoryJWTRevocationStore{revoked: map[string]time.Time{}, now: time.Now}
+}
+
+func (s *MemoryJWTRevocationStore) Revoke(_ context.Context, id string, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for revokedID, expiry := range s.revoked {
+		if !now.Before(expiry) {
+			delete(s.revoked, revokedID)
+		}
+	}
+	if expiry, ok := s.revoked[id]; ok {
+		if until.After(expiry) {
+			s.revoked[id] = until
+		}
+		return true, nil
+	}
+	s.revoked[id] = until
+	return false, nil
+}
+
+func (s *MemoryJWTRevocationStore) IsRevoked(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.revoked[id]
+	return ok && s.now().Before(expiry), nil
+}
+
+// checkJWTRevocation rejects the tokens whose jti or family is revoked.
+func checkJWTRevocation(ctx context.Context, store JWTRevocationStore, claims StrMap) error {
+	for _, key := range []string{JWTClaimID, jwtClaimFamilyID} {
+		id, _ := claims[key].(string)
+		if id == "" {
+			continue
+		}
+		revoked, err := store.IsRevoked(ctx, jwtRevocationID(key, id))
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrJWTRevoked.WithField(key, id)
+		}
+	}
+	return nil
+}
+
+// jwtRevocationID keeps apart token and family IDs.
+func jwtRevocationID(claim, id string) string {
+	if claim == jwtClaimFamilyID {
+		return "family:" + id
+	}
+	return id
+}
+
+// JWTTokenPair is an OAuth 2.0 token response (RFC 6749 5.1).
+type JWTTokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type JWTTokenIssuerOptions struct {
+	// Key signs and verifies tokens, unless Rotator is set.
+	Key     *JWTKey
+	Rotator *JWTKeyRotator
+	// Issuer and Audience are the iss and aud of the tokens, they are validated on refresh.
+	Issuer   string
+	Audience []string
+	// AccessTTL defaults to 15 minutes, RefreshTTL to 30 days.
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// Store records the used refresh tokens and revoked families, default a MemoryJWTRevocationStore.
+	Store JWTRevocationStore
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// JWTTokenIssuer issues access and refresh token pairs. Refresh tokens are single use: a refresh
+// returns a new pair of the same family, and a reused refresh token revokes its family, so that
+// a stolen refresh token works once at most.
+type JWTTokenIssuer struct {
+	opts JWTTokenIssuerOptions
+}
+
+func NewJWTTokenIssuer(opts JWTTokenIssuerOptions) *JWTTokenIssuer {
+	if opts.AccessTTL <= 0 {
+		opts.AccessTTL = 15 * time.Minute
+	}
+	if opts.RefreshTTL <= 0 {
+		opts.RefreshTTL = 30 * 24 * time.Hour
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryJWTRevocationStore()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &JWTTokenIssuer{opts: opts}
+}
+
+// Verifier verifies the access tokens, for the JWT auth middleware along with Store.
+func (i *JWTTokenIssuer) Verifier() *JWTVerifier {
+	verifier := &JWTVerifier{Validator: &JWTValidator{
+		Issuers:        nonEmptyStrings(i.opts.Issuer),
+		Audiences:      i.opts.Audience,
+		RequiredClaims: []string{JWTClaimExpiresAt},
+		Now:            i.opts.Now,
+	}}
+	if i.opts.Rotator != nil {
+		verifier.Algorithms = []string{i.opts.Rotator.SigningKey().Alg()}
+		verifier.KeySource = i.opts.Rotator
+	} else {
+		verifier.Algorithms = []string{i.opts.Key.Alg()}
+		verifier.Keys = []*JWTKey{i.opts.Key}
+	}
+	return verifier
+}
+
+func (i *JWTTokenIssuer) Store() JWTRevocationStore {
+	return i.opts.Store
+}
+
+// Issue returns a pair of a new family for subject, claims are copied in both tokens but for
+// registered claims and the ones of the issuer, which it sets itself.
+func (i *JWTTokenIssuer) Issue(ctx context.Context, subject string, claims StrMap) (*JWTTokenPair, error) {
+	familyID, err := newJWTID()
+	if err != nil {
+		return nil, err
+	}
+	return i.issue(subject, familyID, claims)
+}
+
+// Refresh exchanges a refresh token for a new pair. It returns ErrJWTRefreshTokenReused if the
+// token was already exchanged, ErrJWTRevoked if its family was revoked.
+func (i *JWTTokenIssuer) Refresh(ctx context.Context, refreshToken string) (*JWTTokenPair, error) {
+	payload, err := i.Verifier().ParseContext(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := ParseJWTClaims(payload, false)
+	if err != nil {
+		return nil, err
+	}
+	familyID, _ := claims.Custom[jwtClaimFamilyID].(string)
+	if claims.Custom[jwtClaimTokenUse] != jwtTokenUseRefresh || claims.ID == "" || familyID == "" {
+		return nil, ErrJWTInvalidClaim.WithField("claim", jwtClaimTokenUse)
+	}
+	// the jti is checked by Revoke, to tell reuses
+	revoked, err := i.opts.Store.IsRevoked(ctx, jwtRevocationID(jwtClaimFamilyID, familyID))
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrJWTRevoked.WithField(jwtClaimFamilyID, familyID)
+	}
+	reused, err := i.opts.Store.Revoke(ctx, jwtRevocationID(JWTClaimID, claims.ID), claims.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		if err := i.RevokeFamily(ctx, familyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrJWTRefreshTokenReused.WithField(jwtClaimFamilyID, familyID)
+	}
+	return i.issue(claims.Subject, familyID, claims.Custom)
+}
+
+// Revoke revokes the family of a token, e.g. on logout.
+func (i *JWTTokenIssuer) Revoke(ctx context.Context, token string) error {
+	payload, err := i.Verifier().ParseContext(ctx, token)
+	if err != nil {
+		return err
+	}
+	familyID, _ := payload[jwtClaimFamilyID].(string)
+	if familyID == "" {
+		return ErrJWTMissingClaim.WithField("claim", jwtClaimFamilyID)
+	}
+	return i.RevokeFamily(ctx, familyID)
+}
+
+// RevokeFamily revokes the access and refresh tokens of a family.
+func (i *JWTTokenIssuer) RevokeFamily(ctx context.Context, familyID string) error {
+	until := i.opts.Now().Add(i.opts.RefreshTTL)
+	_, err := i.opts.Store.Revoke(ctx, jwtRevocationID(jwtClaimFamilyID, familyID), until)
+	return err
+}
+
+func (i *JWTTokenIssuer) issue(subject, familyID string, claims StrMap) (*JWTTokenPair, error) {
+	key := i.opts.Key
+	if i.opts.Rotator != nil {
+		key = i.opts.Rotator.SigningKey()
+	}
+	custom := StrMap{}
+	for key, value := range claims {
+		if !isJWTRegisteredClaim(key) && key != jwtClaimTokenUse && key != jwtClaimFamilyID {
+			custom[key] = value
+		}
+	}
+	now := i.opts.Now()
+	newBuilder := func(use string, ttl time.Duration) *JWTBuilder {
+		builder := NewJWTBuilder().Issuer(i.opts.Issuer).Subject(subject).Audience(i.opts.Audience...).
+			IssuedAt(now).ExpiresIn(ttl).GenerateID().Claims(custom)
+		return builder.Claim(jwtClaimTokenUse, use).Claim(jwtClaimFamilyID, familyID)
+	}
+	access, err := newBuilder(jwtTokenUseAccess, i.opts.AccessTTL).SignWithKey(key)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := newBuilder(jwtTokenUseRefresh, i.opts.RefreshTTL).SignWithKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTTokenPair{
+		AccessToken:  access,
+		TokenType:    jwtAuthScheme,
+		ExpiresIn:    int64(i.opts.AccessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+func nonEmptyStrings(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTTokenIssuer(t *testing.T) {
+	ctx := context.Background()
+	key, _ := GenerateJWTKey(JWTAlgES256)
+	issuer := NewJWTTokenIssuer(JWTTokenIssuerOptions{Key: key, Issuer: "auth", Audience: []string{"api"}})
+
+	// the claims of the issuer can't be overridden
+	pair, err := issuer.Issue(ctx, "u1", StrMap{
+		"role": "admin", "exp": int64(4102444800), "nbf": int64(4102444800), "jti": "chosen", "iss": "other", "fid": "chosen", "token_use": "refresh",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, int64(900), pair.ExpiresIn)
+
+	claims, err := issuer.Verifier().Parse(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims["role"])
+	assert.Equal(t, "auth", claims["iss"])
+	assert.Equal(t, "access", claims["token_use"])
+	assert.Nil(t, claims["nbf"])
+	assert.InDelta(t, float64(time.Now().Add(15*time.Minute).Unix()), claims["exp"], 5)
+	for _, key := range []string{"jti", "fid"} {
+		assert.NotEqual(t, "chosen", claims[key], key)
+		assert.Len(t, claims[key], 22, key)
+	}
+
+	// an access token isn't a refresh token
+	_, err = issuer.Refresh(ctx, pair.AccessToken)
+	assert.True(t, errors.Is(err, ErrJWTInvalidClaim))
+
+	refreshed, err := issuer.Refresh(ctx, pair.RefreshToken)
+	assert.NoError(t, err)
+	claims, _ = issuer.Verifier().Parse(refreshed.AccessToken)
+	assert.Equal(t, "u1", claims["sub"])
+	assert.Equal(t, "admin", claims["role"])
+
+	// the reuse of a refresh token revokes its family
+	_, err = issuer.Refresh(ctx, pair.RefreshToken)
+	assert.True(t, errors.Is(err, ErrJWTRefreshTokenReused))
+	_, err = issuer.Refresh(ctx, refreshed.RefreshToken)
+	assert.True(t, errors.Is(err, ErrJWTRevoked))
+
+	handler := NewJWTAuthMiddleware(JWTAuthOptions{Verifier: issuer.Verifier(), Revocations: issuer.Store()})(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	serve := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HTTPHeaderAuthorization, "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(refreshed.AccessToken))
+
+	other, _ := issuer.Issue(ctx, "u2", nil)
+	assert.Equal(t, http.StatusOK, serve(other.AccessToken))
+	assert.Equal(t, http.StatusUnauthorized, serve(other.RefreshToken))
+	assert.NoError(t, issuer.Revoke(ctx, other.AccessToken))
+	assert.Equal(t, http.StatusUnauthorized, serve(other.AccessToken))
+}
+
+func TestMemoryJWTRevocationStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryJWTRevocationStore()
+	store.now = func() time.Time { return now }
+
+	revoked, err := store.Revoke(ctx, "a", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, _ = store.Revoke(ctx, "a", now.Add(time.Minute))
+	assert.True(t, revoked)
+	revoked, _ = store.IsRevoked(ctx, "a")
+	assert.True(t, revoked)
+
+	now = now.Add(time.Minute)
+	revoked, _ = store.IsRevoked(ctx, "a")
+	assert.False(t, revoked)
+	_, _ = store.Revoke(ctx, "b", now.Add(time.Minute))
+	assert.Len(t, store.revoked, 1)
+}
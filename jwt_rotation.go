@@ -0,0 +1,165 @@
+package utility
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type JWTKeyRotatorOptions struct {
+	// Alg of the generated keys, default RS256.
+	Alg string
+	// Generate returns a new signing key, default GenerateJWTKey(Alg). Keys without an ID are
+	// identified by their thumbprint.
+	Generate func() (*JWTKey, error)
+	// Interval between rotations of Start, default 24h.
+	Interval time.Duration
+	// GracePeriod keeps verifying the tokens of a rotated key, default Interval. It should be longer
+	// than the lifetime of the tokens.
+	GracePeriod time.Duration
+	// OnError receives the errors of the rotations of Start.
+	OnError func(err error)
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (opts *JWTKeyRotatorOptions) normalize() {
+	if opts.Alg == "" {
+		opts.Alg = JWTAlgRS256
+	}
+	if opts.Generate == nil {
+		alg := opts.Alg
+		opts.Generate = func() (*JWTKey, error) {
+			return GenerateJWTKey(alg)
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 24 * time.Hour
+	}
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = opts.Interval
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+}
+
+type retiredJWTKey struct {
+	key   *JWTKey
+	until time.Time
+}
+
+// JWTKeyRotator holds the current signing key and the previous ones during their grace period.
+// It is the JWTKeySource of a JWTVerifier of its tokens, and serves the public keys as a JWKS.
+type JWTKeyRotator struct {
+	opts JWTKeyRotatorOptions
+
+	mu      sync.RWMutex
+	current *JWTKey
+	retired []retiredJWTKey
+}
+
+// NewJWTKeyRotator generates the first key.
+func NewJWTKeyRotator(opts JWTKeyRotatorOptions) (*JWTKeyRotator, error) {
+	opts.normalize()
+	r := &JWTKeyRotator{opts: opts}
+	if err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SigningKey returns the current key.
+func (r *JWTKeyRotator) SigningKey() *JWTKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Sign signs payload with the current key, see MakeJWTTokenWithKey.
+func (r *JWTKeyRotator) Sign(payload StrMap) (string, error) {
+	return MakeJWTTokenWithKey(r.SigningKey(), payload)
+}
+
+// Rotate replaces the current key by a new one, the current one is retired for the grace period.
+func (r *JWTKeyRotator) Rotate() error {
+	key, err := r.opts.Generate()
+	if err != nil {
+		return err
+	}
+	if !key.CanSign() {
+		return ErrJWTKeyInvalid.WithFields(StrMap{"alg": key.alg, "reason": "verification key"})
+	}
+	if key.id == "" {
+		key = key.WithID(key.Thumbprint())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.opts.Now()
+	retired := r.retired[:0]
+	for _, k := range r.retired {
+		if now.Before(k.until) {
+			retired = append(retired, k)
+		}
+	}
+	if r.current != nil {
+		retired = append(retired, retiredJWTKey{key: r.current, until: now.Add(r.opts.GracePeriod)})
+	}
+	r.current, r.retired = key, retired
+	return nil
+}
+
+// Start rotates the key every interval until stop is called.
+func (r *JWTKeyRotator) Start() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Rotate(); err != nil && r.opts.OnError != nil {
+					r.opts.OnError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Keys returns the current key followed by the retired keys still in their grace period.
+func (r *JWTKeyRotator) Keys() []*JWTKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.opts.Now()
+	keys := []*JWTKey{r.current}
+	for _, k := range r.retired {
+		if now.Before(k.until) {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys
+}
+
+// JWTKeys implements JWTKeySource.
+func (r *JWTKeyRotator) JWTKeys(_ context.Context, kid string) ([]*JWTKey, error) {
+	return filterJWTKeys(r.Keys(), kid), nil
+}
+
+// JWKSet returns the public keys of Keys.
+func (r *JWTKeyRotator) JWKSet() *JWKSet {
+	return NewJWKSet(r.Keys()...)
+}
+
+// ServeHTTP publishes JWKSet.
+func (r *JWTKeyRotator) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	serveJWKSet(w, r.JWKSet())
+}
@@ -0,0 +1,56 @@
+package utility
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTKeyRotator(t *testing.T) {
+	now := time.Now()
+	rotator, err := NewJWTKeyRotator(JWTKeyRotatorOptions{
+		Alg:         JWTAlgES256,
+		GracePeriod: time.Hour,
+		Now:         func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+	verifier := &JWTVerifier{Algorithms: []string{JWTAlgES256}, KeySource: rotator}
+
+	first := rotator.SigningKey()
+	token, err := rotator.Sign(StrMap{"uid": "12"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, rotator.Rotate())
+	assert.NotEqual(t, first.ID(), rotator.SigningKey().ID())
+	assert.Len(t, rotator.Keys(), 2)
+	_, err = verifier.Parse(token)
+	assert.NoError(t, err)
+
+	now = now.Add(time.Hour)
+	assert.Len(t, rotator.Keys(), 1)
+	_, err = verifier.Parse(token)
+	assert.True(t, errors.Is(err, ErrJWTKeyNotFound))
+
+	w := httptest.NewRecorder()
+	rotator.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	set, err := ParseJWKSet(w.Body.Bytes())
+	assert.NoError(t, err)
+	if assert.Len(t, set.Keys, 1) {
+		assert.Equal(t, rotator.SigningKey().ID(), set.Keys[0].KeyID)
+		assert.Empty(t, set.Keys[0].D)
+	}
+}
+
+func TestJWTKeyRotatorStart(t *testing.T) {
+	rotator, err := NewJWTKeyRotator(JWTKeyRotatorOptions{Alg: JWTAlgHS256, Interval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	first := rotator.SigningKey()
+	stop := rotator.Start()
+	assert.Eventually(t, func() bool {
+		return rotator.SigningKey().ID() != first.ID()
+	}, time.Second, 5*time.Millisecond)
+	stop()
+}
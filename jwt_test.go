@@ -0,0 +1,124 @@
+package utility
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testJWTKey = []byte("secret")
+
+func TestMakeJWTToken(t *testing.T) {
+	token, err := MakeJWTToken("hs256", testJWTKey, StrMap{"uid": "12"})
+	assert.NoError(t, err)
+
+	payload, err := ParseJWTPayload(token, "HS256", testJWTKey)
+	assert.NoError(t, err)
+	claims := payload.(StrMap)
+	assert.Equal(t, "12", claims["uid"])
+	iat := int64(claims["iat"].(float64))
+	assert.InDelta(t, time.Now().Unix(), iat, 5)
+
+	_, err = ParseJWTPayload(token, "HS384", testJWTKey)
+	assert.True(t, errors.Is(err, ErrJWTAlgInvalid))
+	_, err = ParseJWTPayload(token, "HS256", []byte("other"))
+	assert.True(t, errors.Is(err, ErrJWTSignatureInvalid))
+	_, err = ParseJWTPayload("a.b", "HS256", testJWTKey)
+	assert.True(t, errors.Is(err, ErrJWTMalformed))
+}
+
+func TestParseJWTPayloadExpired(t *testing.T) {
+	token, err := MakeJWTToken("", testJWTKey, StrMap{"exp": time.Now().Add(-time.Minute).Unix()})
+	assert.NoError(t, err)
+	_, err = ParseJWTPayload(token, "", testJWTKey)
+	assert.True(t, errors.Is(err, ErrJWTExpired))
+	assert.Equal(t, ErrorCategoryUnauthorized, ErrorCategoryOf(err))
+}
+
+func TestJWTBuilder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	builder := NewJWTBuilder().Issuer("auth").Subject("u1").Audience("api").
+		IssuedAt(now).ExpiresIn(time.Hour).NotBefore(now).GenerateID().Claim("role", "admin")
+	payload := builder.Payload()
+	assert.Equal(t, "auth", payload["iss"])
+	assert.Equal(t, "api", payload["aud"])
+	assert.Equal(t, now.Unix(), payload["iat"])
+	assert.Equal(t, now.Add(time.Hour).Unix(), payload["exp"])
+	assert.Len(t, payload["jti"], jwsEncoding.EncodedLen(jwtIDRandomLength))
+	assert.Equal(t, "admin", payload["role"])
+
+	legacy := NewJWTBuilder().IssuedAt(now).MillisecondTimestamps().Payload()
+	assert.Equal(t, now.Unix()*1000, legacy["iat"])
+	claims, err := ParseJWTClaims(StrMap{"iat": float64(now.Unix() * 1000)}, true)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(claims.IssuedAt))
+}
+
+func TestJWTValidator(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	token, err := NewJWTBuilder().Issuer("auth").Subject("u1").Audience("api", "web").
+		IssuedAt(now).ExpiresIn(time.Minute).NotBefore(now).Sign("HS256", testJWTKey)
+	assert.NoError(t, err)
+
+	validator := &JWTValidator{
+		Issuers:        []string{"auth"},
+		Audiences:      []string{"web"},
+		RequiredClaims: []string{JWTClaimExpiresAt},
+		Now:            func() time.Time { return now },
+	}
+	claims, err := ValidateJWT(token, "HS256", testJWTKey, validator)
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", claims.Subject)
+	assert.Equal(t, []string{"api", "web"}, claims.Audience)
+	assert.True(t, now.Add(time.Minute).Equal(claims.ExpiresAt))
+
+	cases := []struct {
+		name   string
+		modify func(v JWTValidator) JWTValidator
+		err    error
+	}{
+		{"expired", func(v JWTValidator) JWTValidator {
+			v.Now = func() time.Time { return now.Add(time.Minute) }
+			return v
+		}, ErrJWTExpired},
+		{"expired within skew", func(v JWTValidator) JWTValidator {
+			v.Now = func() time.Time { return now.Add(time.Minute) }
+			v.ClockSkew = time.Second
+			return v
+		}, nil},
+		{"not valid yet", func(v JWTValidator) JWTValidator {
+			v.Now = func() time.Time { return now.Add(-time.Second) }
+			return v
+		}, ErrJWTNotValidYet},
+		{"issuer", func(v JWTValidator) JWTValidator {
+			v.Issuers = []string{"other"}
+			return v
+		}, ErrJWTInvalidIssuer},
+		{"audience", func(v JWTValidator) JWTValidator {
+			v.Audiences = []string{"admin"}
+			return v
+		}, ErrJWTInvalidAudience},
+		{"subject", func(v JWTValidator) JWTValidator {
+			v.Subject = "u2"
+			return v
+		}, ErrJWTInvalidSubject},
+		{"required", func(v JWTValidator) JWTValidator {
+			v.RequiredClaims = []string{JWTClaimID}
+			return v
+		}, ErrJWTMissingClaim},
+	}
+	for _, c := range cases {
+		v := c.modify(*validator)
+		_, err := ValidateJWT(token, "HS256", testJWTKey, &v)
+		if c.err == nil {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.True(t, errors.Is(err, c.err), "%s: %v", c.name, err)
+		}
+	}
+
+	_, err = (&JWTValidator{}).Validate(StrMap{"exp": "tomorrow"})
+	assert.True(t, errors.Is(err, ErrJWTInvalidClaim))
+}
@@ -0,0 +1,283 @@
+package utility
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	HTTPHeaderXRequestID = "X-Request-ID"
+
+	requestIDLength    = 12
+	maxRequestIDLength = 128
+)
+
+// MiddlewareChain composes middlewares, the first one is the outermost.
+type MiddlewareChain struct {
+	middlewares []func(http.Handler) http.Handler
+}
+
+func NewMiddlewareChain(middlewares ...func(http.Handler) http.Handler) MiddlewareChain {
+	return MiddlewareChain{middlewares: append([]func(http.Handler) http.Handler(nil), middlewares...)}
+}
+
+// Append returns a new chain with middlewares added inside the ones of c.
+func (c MiddlewareChain) Append(middlewares ...func(http.Handler) http.Handler) MiddlewareChain {
+	all := make([]func(http.Handler) http.Handler, 0, len(c.middlewares)+len(middlewares))
+	return MiddlewareChain{middlewares: append(append(all, c.middlewares...), middlewares...)}
+}
+
+func (c MiddlewareChain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+func (c MiddlewareChain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	return c.Then(fn)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID set by the request ID middleware, "" if none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID returns ctx carrying id, e.g. for jobs started outside of a request.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+type RequestIDOptions struct {
+	// Header carries the ID in requests and responses, default X-Request-ID.
+	Header string
+	// Generate returns a new ID, default GenerateUUID.
+	Generate func() string
+	// IgnoreIncoming always generates an ID instead of keeping a valid one sent by the client.
+	IgnoreIncoming bool
+}
+
+// NewRequestIDMiddleware keeps the request ID sent in the header, or generates one, then puts it
+// in the request context (see RequestIDFromContext) and the response header.
+func NewRequestIDMiddleware(opts RequestIDOptions) func(http.Handler) http.Handler {
+	if opts.Header == "" {
+		opts.Header = HTTPHeaderXRequestID
+	}
+	if opts.Generate == nil {
+		opts.Generate = func() string {
+			return GenerateUUID(requestIDLength)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(opts.Header)
+			if opts.IgnoreIncoming || !isValidRequestID(id) {
+				id = opts.Generate()
+				req.Header.Set(opts.Header, id)
+			}
+			w.Header().Set(opts.Header, id)
+			next.ServeHTTP(w, req.WithContext(ContextWithRequestID(req.Context(), id)))
+		})
+	}
+}
+
+// isValidRequestID accepts up to 128 printable ASCII characters, so that IDs can be logged as is.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDPropagationMiddleware sets the request ID of the request context on outgoing requests,
+// header defaults to X-Request-ID.
+func RequestIDPropagationMiddleware(header string) RoundTripperMiddleware {
+	if header == "" {
+		header = HTTPHeaderXRequestID
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if id := RequestIDFromContext(req.Context()); id != "" && req.Header.Get(header) == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set(header, id)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// responseRecorder records the status and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 && (status < 100 || status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported by the response writer")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Query     string        `json:"query,omitempty"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Latency   time.Duration `json:"latency"`
+	ClientIP  string        `json:"client_ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
+
+type AccessLogOptions struct {
+	// Log receives an entry once a request is served, default logs it as a JSON line with the standard logger.
+	Log func(req *http.Request, entry *AccessLogEntry)
+	// TrustedProxies are believed for the client IP, see ClientIP.
+	TrustedProxies TrustedProxies
+}
+
+// NewAccessLogMiddleware logs every request, including the ones whose handler panics.
+func NewAccessLogMiddleware(opts AccessLogOptions) func(http.Handler) http.Handler {
+	if opts.Log == nil {
+		opts.Log = func(_ *http.Request, entry *AccessLogEntry) {
+			line, _ := json.Marshal(entry)
+			log.Print(string(line))
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			completed := false
+			// deferred without recovering, so a panic keeps propagating with its stack
+			defer func() {
+				status := recorder.status
+				if status == 0 {
+					status = http.StatusOK
+					if !completed {
+						// the response is answered by the server or an outer middleware
+						status = http.StatusInternalServerError
+					}
+				}
+				entry := &AccessLogEntry{
+					Time:      start,
+					RequestID: RequestIDFromContext(req.Context()),
+					Method:    req.Method,
+					Path:      req.URL.Path,
+					Query:     req.URL.RawQuery,
+					Proto:     req.Proto,
+					Status:    status,
+					Bytes:     recorder.bytes,
+					Latency:   time.Since(start),
+					UserAgent: req.UserAgent(),
+				}
+				if ip := ClientIP(req, opts.TrustedProxies); ip != nil {
+					entry.ClientIP = ip.String()
+				}
+				opts.Log(req, entry)
+			}()
+			next.ServeHTTP(recorder, req)
+			completed = true
+		})
+	}
+}
+
+// NewRecoveryMiddleware answers requests whose handler panics with 500 Internal Server Error,
+// unless the response was already started. onPanic receives the panic as an *Error of category
+// ErrorCategoryPanic with its stack, it defaults to logging it with the stack.
+// http.ErrAbortHandler panics are passed on, as they abort the response on purpose.
+func NewRecoveryMiddleware(onPanic func(req *http.Request, err *Error)) func(http.Handler) http.Handler {
+	if onPanic == nil {
+		onPanic = func(req *http.Request, err *Error) {
+			log.Printf("panic serving %s %s: %+v", req.Method, req.URL.Path, err)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			recorder := &responseRecorder{ResponseWriter: w}
+			err := Recover(func() {
+				next.ServeHTTP(recorder, req)
+			})
+			if err == nil {
+				return
+			}
+			if errors.Is(err, http.ErrAbortHandler) {
+				panic(http.ErrAbortHandler)
+			}
+			var panicErr *Error
+			if !errors.As(err, &panicErr) {
+				panicErr = &Error{cause: err, category: ErrorCategoryPanic}
+			}
+			onPanic(req, panicErr)
+			if recorder.status == 0 {
+				http.Error(recorder, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+// NewTimeoutMiddleware bounds the time of a handler, see http.TimeoutHandler: the request context
+// is canceled after timeout and 503 Service Unavailable with message is answered if the handler
+// hasn't returned. Streaming responses (Flush, Hijack) aren't supported within it.
+func NewTimeoutMiddleware(timeout time.Duration, message string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, timeout, message)
+	}
+}
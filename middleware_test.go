@@ -0,0 +1,127 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	tag := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+	base := NewMiddlewareChain(tag("a"), tag("b"))
+	chain := base.Append(tag("c"))
+	base.Append(tag("d"))
+	chain.ThenFunc(func(w http.ResponseWriter, req *http.Request) {
+		order = append(order, "handler")
+	}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"a", "b", "c", "handler"}, order)
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	var entries []*AccessLogEntry
+	var outgoing string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outgoing = req.Header.Get(HTTPHeaderXRequestID)
+	}))
+	defer upstream.Close()
+	client := NewHTTPClient(HTTPClientOptions{
+		BaseURL:     upstream.URL,
+		Middlewares: []RoundTripperMiddleware{RequestIDPropagationMiddleware("")},
+	})
+
+	handler := NewMiddlewareChain(
+		NewRequestIDMiddleware(RequestIDOptions{}),
+		NewAccessLogMiddleware(AccessLogOptions{Log: func(req *http.Request, entry *AccessLogEntry) {
+			entries = append(entries, entry)
+		}}),
+	).ThenFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = client.GetJSON(req.Context(), "/", nil)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/items?x=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	id := w.Header().Get(HTTPHeaderXRequestID)
+	assert.Equal(t, 20, len(id))
+	assert.Equal(t, id, outgoing)
+	entry := entries[0]
+	assert.Equal(t, id, entry.RequestID)
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "/items", entry.Path)
+	assert.Equal(t, "x=1", entry.Query)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, int64(7), entry.Bytes)
+	assert.Equal(t, "192.0.2.1", entry.ClientIP)
+
+	// valid incoming IDs are kept
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HTTPHeaderXRequestID, "abc-123")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", w.Header().Get(HTTPHeaderXRequestID))
+	req.Header.Set(HTTPHeaderXRequestID, "bad id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.NotEqual(t, "bad id", w.Header().Get(HTTPHeaderXRequestID))
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var recovered *Error
+	var entry *AccessLogEntry
+	handler := NewMiddlewareChain(
+		NewRecoveryMiddleware(func(req *http.Request, err *Error) {
+			recovered = err
+		}),
+		NewAccessLogMiddleware(AccessLogOptions{Log: func(req *http.Request, e *AccessLogEntry) {
+			entry = e
+		}}),
+	).ThenFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(io.ErrUnexpectedEOF)
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusInternalServerError, entry.Status)
+	assert.True(t, errors.Is(recovered, io.ErrUnexpectedEOF))
+	assert.Equal(t, ErrorCategoryPanic, recovered.Category())
+	// the stack starts at the panic site, the access log doesn't panic again
+	assert.True(t, strings.HasPrefix(recovered.StackTrace(), "github.com/kyl2016/utility.TestRecoveryMiddleware.func"), recovered.StackTrace())
+
+	assert.Panics(t, func() {
+		NewRecoveryMiddleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	canceled := make(chan error, 1)
+	handler := NewTimeoutMiddleware(10*time.Millisecond, "timeout")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		canceled <- req.Context().Err()
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "timeout"))
+	assert.Equal(t, context.DeadlineExceeded, <-canceled)
+}
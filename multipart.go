@@ -0,0 +1,542 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ErrorCodeMultipartTooLarge ErrorCode = "multipart_too_large"
+	ErrorCodeNotMultipart      ErrorCode = "not_multipart"
+
+	defaultMultipartMaxMemory    = 1 << 20
+	defaultMultipartMaxTotalSize = 32 << 20
+	defaultMultipartMaxParts     = 1000
+)
+
+var (
+	ErrMultipartTooLarge = NewError("multipart content too large").
+				WithCode(ErrorCodeMultipartTooLarge).WithCategory(ErrorCategoryInvalidArgument)
+	ErrNotMultipart = NewError("content is not " + ContentTypeFormData).
+			WithCode(ErrorCodeNotMultipart).WithCategory(ErrorCategoryInvalidArgument)
+)
+
+type multipartBuilderPart struct {
+	header textproto.MIMEHeader
+	// path is opened when the part is read, else data or body is read
+	path string
+	data []byte
+	body io.Reader
+	size int64
+}
+
+// MultipartBuilder builds a multipart/form-data body streamed from its parts, files are
+// read from disk while the body is read and never buffered.
+type MultipartBuilder struct {
+	boundary string
+	parts    []*multipartBuilderPart
+	err      error
+	// Progress is called as the body is read with the bytes read so far and the total size, -1 if unknown.
+	Progress func(read, total int64)
+}
+
+func NewMultipartBuilder() *MultipartBuilder {
+	return &MultipartBuilder{boundary: multipart.NewWriter(nil).Boundary()}
+}
+
+func (b *MultipartBuilder) Boundary() string {
+	return b.boundary
+}
+
+// ContentType returns the Content-Type of the body, including its boundary.
+func (b *MultipartBuilder) ContentType() string {
+	return mime.FormatMediaType(ContentTypeFormData, map[string]string{"boundary": b.boundary})
+}
+
+func (b *MultipartBuilder) AddField(name, value string) *MultipartBuilder {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", formDataDisposition(name, ""))
+	b.parts = append(b.parts, &multipartBuilderPart{header: header, data: []byte(value), size: int64(len(value))})
+	return b
+}
+
+// AddFile adds the file at path as field, with a Content-Type guessed from its extension.
+func (b *MultipartBuilder) AddFile(field, path string) *MultipartBuilder {
+	return b.AddFileWithHeader(field, path, nil)
+}
+
+// AddFileWithHeader adds the file at path as field, header overrides the default part headers.
+// The file is opened once the body reaches it, its size is taken now.
+func (b *MultipartBuilder) AddFileWithHeader(field, path string, header textproto.MIMEHeader) *MultipartBuilder {
+	info, err := os.Stat(path)
+	if err != nil {
+		b.err = AppendError(b.err, err)
+		return b
+	}
+	if info.IsDir() {
+		b.err = AppendError(b.err, fmt.Errorf("multipart file %s is a directory", path))
+		return b
+	}
+	b.parts = append(b.parts, &multipartBuilderPart{
+		header: b.fileHeader(field, filepath.Base(path), header),
+		path:   path,
+		size:   info.Size(),
+	})
+	return b
+}
+
+// AddFileReader adds r as a file part, size is -1 if unknown, which leaves the body size unknown.
+func (b *MultipartBuilder) AddFileReader(field, filename string, r io.Reader, size int64) *MultipartBuilder {
+	return b.AddPart(b.fileHeader(field, filename, nil), r, size)
+}
+
+// AddPart adds a part of custom headers, size is -1 if unknown.
+func (b *MultipartBuilder) AddPart(header textproto.MIMEHeader, r io.Reader, size int64) *MultipartBuilder {
+	b.parts = append(b.parts, &multipartBuilderPart{header: header, body: r, size: size})
+	return b
+}
+
+func (b *MultipartBuilder) fileHeader(field, filename string, custom textproto.MIMEHeader) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", formDataDisposition(field, filename))
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = ContentTypeBIN
+	}
+	header.Set(HTTPHeaderContentType, contentType)
+	for key, values := range custom {
+		header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+	return header
+}
+
+func formDataDisposition(name, filename string) string {
+	params := map[string]string{"name": name}
+	if filename != "" {
+		params["filename"] = filename
+	}
+	return mime.FormatMediaType("form-data", params)
+}
+
+// Build returns the body and its size, -1 if a part is of unknown size.
+// Parts added as readers are consumed by reading the body, so it can be built again
+// only if all parts are files or fields.
+func (b *MultipartBuilder) Build() (io.ReadCloser, int64, error) {
+	if b.err != nil {
+		return nil, 0, b.err
+	}
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		return nil, 0, err
+	}
+	body := &multipartBody{}
+	var size int64
+	unknownSize := false
+	for _, part := range b.parts {
+		buf.Reset()
+		if _, err := writer.CreatePart(part.header); err != nil {
+			return nil, 0, err
+		}
+		body.readers = append(body.readers, bytes.NewReader(copyBufferBytes(&buf)))
+		size += int64(buf.Len())
+		if part.path != "" {
+			body.readers = append(body.readers, &lazyFileReader{path: part.path, body: body})
+		} else if part.data != nil {
+			body.readers = append(body.readers, bytes.NewReader(part.data))
+		} else {
+			body.readers = append(body.readers, part.body)
+		}
+		if part.size < 0 {
+			unknownSize = true
+		}
+		size += part.size
+	}
+	buf.Reset()
+	if err := writer.Close(); err != nil {
+		return nil, 0, err
+	}
+	body.readers = append(body.readers, bytes.NewReader(copyBufferBytes(&buf)))
+	size += int64(buf.Len())
+	if unknownSize {
+		size = -1
+	}
+	body.reader = io.MultiReader(body.readers...)
+	body.total = size
+	body.progress = b.Progress
+	return body, size, nil
+}
+
+// NewRequest builds a request posting the body, its GetBody builds it again if possible.
+func (b *MultipartBuilder) NewRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	body, size, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	req.Header.Set(HTTPHeaderContentType, b.ContentType())
+	req.ContentLength = size
+	if size >= 0 && b.rebuildable() {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, _, err := b.Build()
+			return body, err
+		}
+	}
+	return req, nil
+}
+
+func (b *MultipartBuilder) rebuildable() bool {
+	for _, part := range b.parts {
+		if part.path == "" && part.data == nil {
+			return false
+		}
+	}
+	return true
+}
+
+type multipartBody struct {
+	readers  []io.Reader
+	reader   io.Reader
+	open     *os.File
+	read     int64
+	total    int64
+	progress func(read, total int64)
+}
+
+func (b *multipartBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if n > 0 && b.progress != nil {
+		b.read += int64(n)
+		b.progress(b.read, b.total)
+	}
+	return n, err
+}
+
+// Close closes the file being read, if any.
+func (b *multipartBody) Close() error {
+	if b.open == nil {
+		return nil
+	}
+	err := b.open.Close()
+	b.open = nil
+	return err
+}
+
+type lazyFileReader struct {
+	path string
+	body *multipartBody
+	file *os.File
+	done bool
+}
+
+func (r *lazyFileReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.file == nil {
+		file, err := os.Open(r.path)
+		if err != nil {
+			return 0, err
+		}
+		r.file = file
+		r.body.open = file
+	}
+	n, err := r.file.Read(p)
+	if err == io.EOF {
+		r.done = true
+		_ = r.body.Close()
+	}
+	return n, err
+}
+
+// PostMultipart posts the body of b to path, see Do.
+func (c *HTTPClient) PostMultipart(ctx context.Context, path string, b *MultipartBuilder) (*http.Response, error) {
+	req, err := b.NewRequest(ctx, http.MethodPost, c.resolveURL(path))
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+type MultipartOptions struct {
+	// MaxPartSize bounds the size of each part, 0 means no limit.
+	MaxPartSize int64
+	// MaxTotalSize bounds the size of all parts, default 32MB, a negative value means no limit.
+	MaxTotalSize int64
+	// MaxParts bounds the number of parts, default 1000, a negative value means no limit.
+	MaxParts int
+	// MaxMemory is how many bytes of all parts ReadForm keeps in memory, default 1MB.
+	// Parts that don't fit in what is left are spilled to temporary files.
+	MaxMemory int64
+	// TempDir is where parts are spilled, default os.TempDir().
+	TempDir string
+}
+
+// MultipartReader streams the parts of a multipart body, enforcing the limits of MultipartOptions.
+// Exceeding a limit fails reading with ErrMultipartTooLarge.
+type MultipartReader struct {
+	reader *multipart.Reader
+	opts   MultipartOptions
+	parts  int
+	total  int64
+	part   *MultipartStreamPart
+	// memory left to ReadForm
+	memory int64
+	// err is the error of exceeding MaxTotalSize, returned by all later reads
+	err error
+}
+
+// NewMultipartReader reads the multipart/form-data body of req, ErrNotMultipart is returned for other content.
+func NewMultipartReader(req *http.Request, opts MultipartOptions) (*MultipartReader, error) {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get(HTTPHeaderContentType))
+	if err != nil || mediaType != ContentTypeFormData || params["boundary"] == "" || req.Body == nil {
+		return nil, ErrNotMultipart.WithField("content_type", req.Header.Get(HTTPHeaderContentType))
+	}
+	return NewMultipartReaderWithBoundary(req.Body, params["boundary"], opts), nil
+}
+
+func NewMultipartReaderWithBoundary(r io.Reader, boundary string, opts MultipartOptions) *MultipartReader {
+	if opts.MaxMemory <= 0 {
+		opts.MaxMemory = defaultMultipartMaxMemory
+	}
+	if opts.MaxTotalSize == 0 {
+		opts.MaxTotalSize = defaultMultipartMaxTotalSize
+	}
+	if opts.MaxParts == 0 {
+		opts.MaxParts = defaultMultipartMaxParts
+	}
+	return &MultipartReader{reader: multipart.NewReader(r, boundary), opts: opts, memory: opts.MaxMemory}
+}
+
+// MultipartStreamPart is a part being read, valid until the next call of NextPart.
+type MultipartStreamPart struct {
+	FormName string
+	FileName string
+	Header   textproto.MIMEHeader
+	// Size is the number of bytes read so far.
+	Size int64
+
+	part   *multipart.Part
+	reader *MultipartReader
+	// err is the error of exceeding MaxPartSize, returned by all later reads
+	err error
+}
+
+func (p *MultipartStreamPart) Read(b []byte) (int, error) {
+	r := p.reader
+	if p.err != nil {
+		return 0, p.err
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	limit := r.opts.MaxPartSize - p.Size
+	if total := r.opts.MaxTotalSize - r.total; r.opts.MaxTotalSize > 0 && (r.opts.MaxPartSize <= 0 || total < limit) {
+		limit = total
+	}
+	if (r.opts.MaxPartSize > 0 || r.opts.MaxTotalSize > 0) && int64(len(b)) > limit+1 {
+		// read one byte over the limit to detect exceeding it
+		b = b[:limit+1]
+	}
+	n, err := p.part.Read(b)
+	p.Size += int64(n)
+	r.total += int64(n)
+	if r.opts.MaxPartSize > 0 && p.Size > r.opts.MaxPartSize {
+		p.err = ErrMultipartTooLarge.WithField("part", p.FormName).WithField("limit", r.opts.MaxPartSize)
+		return n - int(p.Size-r.opts.MaxPartSize), p.err
+	}
+	if r.opts.MaxTotalSize > 0 && r.total > r.opts.MaxTotalSize {
+		r.err = ErrMultipartTooLarge.WithField("limit", r.opts.MaxTotalSize)
+		return n - int(r.total-r.opts.MaxTotalSize), r.err
+	}
+	return n, err
+}
+
+// NextPart returns the next part, io.EOF after the last one.
+func (r *MultipartReader) NextPart() (*MultipartStreamPart, error) {
+	if r.part != nil {
+		// the rest of the previous part counts against the limits
+		if _, err := io.Copy(io.Discard, r.part); err != nil {
+			return nil, err
+		}
+	}
+	if r.opts.MaxParts > 0 && r.parts >= r.opts.MaxParts {
+		if _, err := r.reader.NextPart(); err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrMultipartTooLarge.WithField("max_parts", r.opts.MaxParts)
+	}
+	part, err := r.reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	r.parts++
+	r.part = &MultipartStreamPart{
+		FormName: part.FormName(),
+		FileName: part.FileName(),
+		Header:   part.Header,
+		part:     part,
+		reader:   r,
+	}
+	return r.part, nil
+}
+
+// ReadForm reads all parts, keeping them in memory up to MaxMemory bytes in total and spilling
+// the others to temporary files. Call RemoveAll on the form to delete them.
+func (r *MultipartReader) ReadForm() (*MultipartForm, error) {
+	form := &MultipartForm{}
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return form, nil
+		} else if err != nil {
+			_ = form.RemoveAll()
+			return nil, err
+		}
+		formPart, err := r.readPart(part)
+		if err != nil {
+			_ = form.RemoveAll()
+			return nil, err
+		}
+		form.Parts = append(form.Parts, formPart)
+	}
+}
+
+func (r *MultipartReader) readPart(part *MultipartStreamPart) (*MultipartFormPart, error) {
+	formPart := &MultipartFormPart{FormName: part.FormName, FileName: part.FileName, Header: part.Header}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, r.memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= r.memory {
+		r.memory -= n
+		formPart.data = buf.Bytes()
+		formPart.Size = n
+		return formPart, nil
+	}
+	file, err := os.CreateTemp(r.opts.TempDir, "multipart-*")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	formPart.path = file.Name()
+	size, err := io.Copy(file, io.MultiReader(&buf, part))
+	if err != nil {
+		_ = os.Remove(formPart.path)
+		return nil, err
+	}
+	formPart.Size = size
+	return formPart, nil
+}
+
+// ParseMultipartRequest reads the whole multipart/form-data body of req, see MultipartReader.ReadForm.
+func ParseMultipartRequest(req *http.Request, opts MultipartOptions) (*MultipartForm, error) {
+	reader, err := NewMultipartReader(req, opts)
+	if err != nil {
+		return nil, err
+	}
+	return reader.ReadForm()
+}
+
+type MultipartForm struct {
+	Parts []*MultipartFormPart
+}
+
+// Value returns the content of the first non-file part named name.
+func (f *MultipartForm) Value(name string) string {
+	for _, part := range f.Parts {
+		if part.FormName == name && part.FileName == "" {
+			if part.path != "" {
+				bs, _ := ReadFileFromPath(part.path)
+				return string(bs)
+			}
+			return string(part.data)
+		}
+	}
+	return ""
+}
+
+// File returns the first file part named name, nil if there is none.
+func (f *MultipartForm) File(name string) *MultipartFormPart {
+	for _, part := range f.Parts {
+		if part.FormName == name && part.FileName != "" {
+			return part
+		}
+	}
+	return nil
+}
+
+// RemoveAll deletes the temporary files of the parts.
+func (f *MultipartForm) RemoveAll() error {
+	var err error
+	for _, part := range f.Parts {
+		if part.path != "" && !part.saved {
+			if removeErr := os.Remove(part.path); removeErr != nil && !os.IsNotExist(removeErr) {
+				err = AppendError(err, removeErr)
+			}
+		}
+	}
+	return err
+}
+
+type MultipartFormPart struct {
+	FormName string
+	FileName string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	data []byte
+	path string
+	// saved parts are not temporary anymore
+	saved bool
+}
+
+// Path returns the temporary file holding the part, "" if it is in memory.
+func (p *MultipartFormPart) Path() string {
+	return p.path
+}
+
+func (p *MultipartFormPart) Open() (io.ReadCloser, error) {
+	if p.path != "" {
+		return os.Open(p.path)
+	}
+	return io.NopCloser(bytes.NewReader(p.data)), nil
+}
+
+// SaveTo moves the temporary file of the part to path, or writes the part to path if it is in memory.
+func (p *MultipartFormPart) SaveTo(path string) error {
+	if p.path == "" || p.saved {
+		rc, err := p.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(file, rc); err != nil {
+			_ = file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	if err := MoveFile(p.path, path, true); err != nil {
+		return err
+	}
+	p.path, p.saved = path, true
+	return nil
+}
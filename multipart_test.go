@@ -0,0 +1,144 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipartBuilder(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "voice.mp3")
+	content := bytes.Repeat([]byte("mp3"), 1000)
+	assert.Nil(t, os.WriteFile(audio, content, 0644))
+
+	var progress []int64
+	builder := NewMultipartBuilder().
+		AddField("title", "voice").
+		AddFileWithHeader("audio", audio, textproto.MIMEHeader{"X-Duration": {"3"}})
+	builder.Progress = func(read, total int64) {
+		progress = append(progress, read)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.True(t, req.ContentLength > int64(len(content)))
+		form, err := ParseMultipartRequest(req, MultipartOptions{MaxMemory: 100})
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer form.RemoveAll()
+		assert.Equal(t, "voice", form.Value("title"))
+		file := form.File("audio")
+		assert.Equal(t, "voice.mp3", file.FileName)
+		assert.Equal(t, "audio/mpeg", file.Header.Get(HTTPHeaderContentType))
+		assert.Equal(t, "3", file.Header.Get("X-Duration"))
+		assert.Equal(t, int64(len(content)), file.Size)
+		assert.NotEqual(t, "", file.Path())
+		rc, _ := file.Open()
+		bs, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		assert.Equal(t, content, bs)
+	}))
+	defer server.Close()
+
+	resp, err := NewHTTPClient(HTTPClientOptions{BaseURL: server.URL}).PostMultipart(context.Background(), "/upload", builder)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, len(progress) > 0)
+
+	// the body can be built again
+	body, size, err := builder.Build()
+	assert.Nil(t, err)
+	bs, _ := io.ReadAll(body)
+	assert.Equal(t, size, int64(len(bs)))
+	assert.Equal(t, size, progress[len(progress)-1])
+
+	_, _, err = NewMultipartBuilder().AddFile("f", filepath.Join(dir, "missing")).Build()
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestMultipartReaderLimits(t *testing.T) {
+	newReader := func(opts MultipartOptions) *MultipartReader {
+		builder := NewMultipartBuilder().
+			AddField("a", "12345").
+			AddFileReader("b", "b.txt", bytes.NewReader(bytes.Repeat([]byte("x"), 20)), -1)
+		body, size, err := builder.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-1), size)
+		data, _ := io.ReadAll(body)
+		return NewMultipartReaderWithBoundary(bytes.NewReader(data), builder.Boundary(), opts)
+	}
+
+	form, err := newReader(MultipartOptions{MaxPartSize: 20, MaxTotalSize: 25}).ReadForm()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(form.Parts))
+	assert.Equal(t, "", form.Parts[1].Path())
+
+	_, err = newReader(MultipartOptions{MaxPartSize: 19}).ReadForm()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+	_, err = newReader(MultipartOptions{MaxTotalSize: 24}).ReadForm()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+	_, err = newReader(MultipartOptions{MaxParts: 1}).ReadForm()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+	form, err = newReader(MultipartOptions{MaxParts: -1, MaxTotalSize: -1}).ReadForm()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(form.Parts))
+
+	// MaxMemory is shared by the parts, the second one doesn't fit in what is left
+	form, err = newReader(MultipartOptions{MaxMemory: 24}).ReadForm()
+	assert.Nil(t, err)
+	assert.Equal(t, "", form.Parts[0].Path())
+	assert.NotEqual(t, "", form.Parts[1].Path())
+	assert.Equal(t, int64(20), form.Parts[1].Size)
+	assert.Nil(t, form.RemoveAll())
+
+	// parts not read count against the total
+	reader := newReader(MultipartOptions{MaxTotalSize: 10})
+	_, err = reader.NextPart()
+	assert.Nil(t, err)
+	_, err = reader.NextPart()
+	assert.Nil(t, err)
+	_, err = reader.NextPart()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+
+	// reads after the limit keep failing without data
+	for _, opts := range []MultipartOptions{{MaxPartSize: 10}, {MaxTotalSize: 10}} {
+		reader = newReader(opts)
+		_, err = reader.NextPart()
+		assert.Nil(t, err)
+		part, err := reader.NextPart()
+		assert.Nil(t, err)
+		p := make([]byte, 100)
+		n, err := part.Read(p)
+		assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+		assert.True(t, n >= 0 && n <= 10, n)
+		n, err = part.Read(p)
+		assert.Equal(t, 0, n)
+		assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+	}
+
+	// the limits are finite by default
+	builder := NewMultipartBuilder()
+	for i := 0; i <= defaultMultipartMaxParts; i++ {
+		builder.AddField("f", "v")
+	}
+	body, _, err := builder.Build()
+	assert.Nil(t, err)
+	_, err = NewMultipartReaderWithBoundary(body, builder.Boundary(), MultipartOptions{}).ReadForm()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil))
+	req.Header.Set(HTTPHeaderContentType, ContentTypeJSON)
+	_, err = ParseMultipartRequest(req, MultipartOptions{})
+	assert.True(t, errors.Is(err, ErrNotMultipart))
+}
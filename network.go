@@ -0,0 +1,390 @@
+package utility
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"path"
+	"strings"
+)
+
+const ErrorCodeIPNotFound ErrorCode = "ip_not_found"
+
+var ErrIPNotFound = NewError("no matching IP address found").
+	WithCode(ErrorCodeIPNotFound).WithCategory(ErrorCategoryNotFound)
+
+// DefaultVirtualInterfaces are name patterns of container, VM and VPN interfaces, see path.Match.
+var DefaultVirtualInterfaces = []string{
+	"docker*", "br-*", "veth*", "virbr*", "vmnet*", "vboxnet*", "cni*", "flannel*", "cali*", "kube-*", "tun*", "tap*", "utun*",
+}
+
+type IPFamily int
+
+const (
+	IPFamilyAny IPFamily = iota
+	IPv4
+	IPv6
+)
+
+// IPFamilyOf returns IPv4 for IPv4 and IPv4-mapped IPv6 addresses.
+func IPFamilyOf(ip net.IP) IPFamily {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	if len(ip) == net.IPv6len {
+		return IPv6
+	}
+	return IPFamilyAny
+}
+
+type IPScope int
+
+const (
+	IPScopeUnspecified IPScope = iota
+	IPScopeLoopback
+	IPScopeLinkLocal
+	IPScopeMulticast
+	// IPScopePrivate covers RFC 1918 and RFC 4193 ranges.
+	IPScopePrivate
+	IPScopeGlobal
+)
+
+func (s IPScope) String() string {
+	switch s {
+	case IPScopeLoopback:
+		return "loopback"
+	case IPScopeLinkLocal:
+		return "link-local"
+	case IPScopeMulticast:
+		return "multicast"
+	case IPScopePrivate:
+		return "private"
+	case IPScopeGlobal:
+		return "global"
+	}
+	return "unspecified"
+}
+
+func IPScopeOf(ip net.IP) IPScope {
+	switch {
+	case ip == nil || ip.IsUnspecified():
+		return IPScopeUnspecified
+	case ip.IsLoopback():
+		return IPScopeLoopback
+	case ip.IsLinkLocalUnicast():
+		return IPScopeLinkLocal
+	case ip.IsMulticast():
+		return IPScopeMulticast
+	case ip.IsPrivate():
+		return IPScopePrivate
+	}
+	return IPScopeGlobal
+}
+
+type NetInterface struct {
+	Name         string
+	Index        int
+	MTU          int
+	HardwareAddr net.HardwareAddr
+	Flags        net.Flags
+	Addrs        []*net.IPNet
+}
+
+func (i *NetInterface) IsUp() bool {
+	return i.Flags&net.FlagUp != 0
+}
+
+func (i *NetInterface) IsLoopback() bool {
+	return i.Flags&net.FlagLoopback != 0
+}
+
+// IsVirtual reports whether the name of the interface matches DefaultVirtualInterfaces.
+func (i *NetInterface) IsVirtual() bool {
+	return matchInterfaceName(i.Name, DefaultVirtualInterfaces)
+}
+
+// NetInterfaces lists the interfaces of the host with their addresses.
+func NetInterfaces() ([]*NetInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*NetInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		netIface := &NetInterface{
+			Name:         iface.Name,
+			Index:        iface.Index,
+			MTU:          iface.MTU,
+			HardwareAddr: iface.HardwareAddr,
+			Flags:        iface.Flags,
+		}
+		for _, addr := range addrs {
+			switch v := addr.(type) {
+			case *net.IPNet:
+				netIface.Addrs = append(netIface.Addrs, v)
+			case *net.IPAddr:
+				bits := 8 * len(v.IP)
+				netIface.Addrs = append(netIface.Addrs, &net.IPNet{IP: v.IP, Mask: net.CIDRMask(bits, bits)})
+			}
+		}
+		result = append(result, netIface)
+	}
+	return result, nil
+}
+
+// InterfaceAddrFilter selects interface addresses, zero values select all.
+type InterfaceAddrFilter struct {
+	Family IPFamily
+	Scopes []IPScope
+	// UpOnly skips interfaces that are down.
+	UpOnly bool
+	// Include are name patterns of the interfaces to select, see path.Match.
+	Include []string
+	// Exclude are name patterns of the interfaces to skip, e.g. DefaultVirtualInterfaces.
+	Exclude []string
+}
+
+type InterfaceAddr struct {
+	Interface *NetInterface
+	IP        net.IP
+	Network   *net.IPNet
+}
+
+// InterfaceAddrs returns the addresses of the host selected by filter, in interface order.
+func InterfaceAddrs(filter InterfaceAddrFilter) ([]InterfaceAddr, error) {
+	ifaces, err := NetInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	return filterInterfaceAddrs(ifaces, filter), nil
+}
+
+func filterInterfaceAddrs(ifaces []*NetInterface, filter InterfaceAddrFilter) []InterfaceAddr {
+	var result []InterfaceAddr
+	for _, iface := range ifaces {
+		if filter.UpOnly && !iface.IsUp() ||
+			len(filter.Include) > 0 && !matchInterfaceName(iface.Name, filter.Include) ||
+			matchInterfaceName(iface.Name, filter.Exclude) {
+			continue
+		}
+		for _, addr := range iface.Addrs {
+			if filter.Family != IPFamilyAny && IPFamilyOf(addr.IP) != filter.Family {
+				continue
+			}
+			if len(filter.Scopes) > 0 && !containsIPScope(filter.Scopes, IPScopeOf(addr.IP)) {
+				continue
+			}
+			result = append(result, InterfaceAddr{Interface: iface, IP: addr.IP, Network: addr})
+		}
+	}
+	return result
+}
+
+func matchInterfaceName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIPScope(scopes []IPScope, scope IPScope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLanIP returns the first private IPv4 of an up, non-virtual interface, else the first global one.
+func selectLanIP(ifaces []*NetInterface) (net.IP, error) {
+	filter := InterfaceAddrFilter{Family: IPv4, UpOnly: true, Exclude: DefaultVirtualInterfaces}
+	for _, scope := range []IPScope{IPScopePrivate, IPScopeGlobal} {
+		filter.Scopes = []IPScope{scope}
+		if addrs := filterInterfaceAddrs(ifaces, filter); len(addrs) > 0 {
+			return addrs[0].IP.To4(), nil
+		}
+	}
+	return nil, ErrIPNotFound
+}
+
+// PreferredOutboundIP returns the source address the host routes outbound traffic of family from.
+// It only consults the routing table by connecting a UDP socket to a documentation address,
+// no packet is sent. Without a default route, the LAN IP is returned for IPv4.
+func PreferredOutboundIP(family IPFamily) (net.IP, error) {
+	network, target := "udp4", "192.0.2.1:9"
+	if family == IPv6 {
+		network, target = "udp6", "[2001:db8::1]:9"
+	}
+	conn, err := net.Dial(network, target)
+	if err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP, nil
+		}
+	}
+	if family == IPv6 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrIPNotFound
+	}
+	return GetLanIP()
+}
+
+// ParseNetwork parses a CIDR such as "10.0.0.0/8", a plain IP is taken as a single address network.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	bits := 8 * len(ip)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// NetworkContains reports whether inner is a subnet of, or equal to, outer.
+func NetworkContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// NetworksOverlap reports whether a and b share an address.
+func NetworksOverlap(a, b *net.IPNet) bool {
+	return NetworkContains(a, b) || NetworkContains(b, a)
+}
+
+// IPRange is the inclusive range of addresses from First to Last, of the same family.
+type IPRange struct {
+	First net.IP
+	Last  net.IP
+}
+
+// ParseIPRange parses "10.0.0.1-10.0.0.9" or a CIDR.
+func ParseIPRange(s string) (IPRange, error) {
+	if first, last, found := strings.Cut(s, "-"); found {
+		r := IPRange{First: normalizeIP(net.ParseIP(strings.TrimSpace(first))), Last: normalizeIP(net.ParseIP(strings.TrimSpace(last)))}
+		if r.First == nil || r.Last == nil || len(r.First) != len(r.Last) || compareIP(r.First, r.Last) > 0 {
+			return IPRange{}, &net.ParseError{Type: "IP range", Text: s}
+		}
+		return r, nil
+	}
+	network, err := ParseNetwork(s)
+	if err != nil {
+		return IPRange{}, err
+	}
+	return NetworkRange(network), nil
+}
+
+// NetworkRange returns the range of all addresses of network, including network and broadcast addresses.
+func NetworkRange(network *net.IPNet) IPRange {
+	first := normalizeIP(network.IP.Mask(network.Mask))
+	last := make(net.IP, len(first))
+	mask := network.Mask
+	if len(mask) != len(first) {
+		mask = mask[len(mask)-len(first):]
+	}
+	for i := range first {
+		last[i] = first[i] | ^mask[i]
+	}
+	return IPRange{First: first, Last: last}
+}
+
+func (r IPRange) Contains(ip net.IP) bool {
+	ip = normalizeIP(ip)
+	return len(ip) == len(r.First) && compareIP(r.First, ip) <= 0 && compareIP(ip, r.Last) <= 0
+}
+
+// Size returns the number of addresses in the range.
+func (r IPRange) Size() *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(r.Last), new(big.Int).SetBytes(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+// Each calls fn with each address of the range in order until it returns false.
+func (r IPRange) Each(fn func(ip net.IP) bool) {
+	for ip := r.First; ip != nil && compareIP(ip, r.Last) <= 0; ip = NextIP(ip) {
+		if !fn(ip) {
+			return
+		}
+	}
+}
+
+func (r IPRange) String() string {
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// NextIP returns the address after ip, nil if ip is the last of its family.
+func NextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), normalizeIP(ip)...)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// PrevIP returns the address before ip, nil if ip is the first of its family.
+func PrevIP(ip net.IP) net.IP {
+	prev := append(net.IP(nil), normalizeIP(ip)...)
+	for i := len(prev) - 1; i >= 0; i-- {
+		if prev[i]--; prev[i] != 0xff {
+			return prev
+		}
+	}
+	return nil
+}
+
+// maxSubnets bounds the result of SplitNetwork.
+const maxSubnets = 1 << 16
+
+// SplitNetwork splits network into subnets of prefix length prefix.
+func SplitNetwork(network *net.IPNet, prefix int) ([]*net.IPNet, error) {
+	ones, bits := network.Mask.Size()
+	if prefix < ones || prefix > bits {
+		return nil, fmt.Errorf("prefix /%d out of range /%d-/%d of %s", prefix, ones, bits, network)
+	}
+	if prefix-ones > 16 {
+		return nil, fmt.Errorf("splitting %s into /%d exceeds %d subnets", network, prefix, maxSubnets)
+	}
+	mask := net.CIDRMask(prefix, bits)
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-prefix))
+	current := new(big.Int).SetBytes(normalizeIP(network.IP.Mask(network.Mask)))
+	count := 1 << uint(prefix-ones)
+	subnets := make([]*net.IPNet, 0, count)
+	for i := 0; i < count; i++ {
+		ip := make(net.IP, bits/8)
+		current.FillBytes(ip)
+		subnets = append(subnets, &net.IPNet{IP: ip, Mask: mask})
+		current.Add(current, step)
+	}
+	return subnets, nil
+}
+
+// normalizeIP returns IPv4 addresses in their 4-byte form.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func compareIP(a, b net.IP) int {
+	return bytes.Compare(normalizeIP(a), normalizeIP(b))
+}
@@ -0,0 +1,123 @@
+package utility
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPScope(t *testing.T) {
+	assert.Equal(t, IPScopeLoopback, IPScopeOf(net.ParseIP("::1")))
+	assert.Equal(t, IPScopeLinkLocal, IPScopeOf(net.ParseIP("169.254.1.1")))
+	assert.Equal(t, IPScopePrivate, IPScopeOf(net.ParseIP("172.17.0.1")))
+	assert.Equal(t, IPScopePrivate, IPScopeOf(net.ParseIP("fd00::1")))
+	assert.Equal(t, IPScopeGlobal, IPScopeOf(net.ParseIP("8.8.8.8")))
+	assert.Equal(t, IPScopeUnspecified, IPScopeOf(net.ParseIP("::")))
+	assert.Equal(t, IPv4, IPFamilyOf(net.ParseIP("::ffff:1.2.3.4")))
+	assert.Equal(t, IPv6, IPFamilyOf(net.ParseIP("2001:db8::1")))
+}
+
+func TestFilterInterfaceAddrs(t *testing.T) {
+	network := func(s string) *net.IPNet {
+		ip, n, _ := net.ParseCIDR(s)
+		n.IP = ip
+		return n
+	}
+	ifaces := []*NetInterface{
+		{Name: "lo", Flags: net.FlagUp | net.FlagLoopback, Addrs: []*net.IPNet{network("127.0.0.1/8"), network("::1/128")}},
+		{Name: "docker0", Flags: net.FlagUp, Addrs: []*net.IPNet{network("172.17.0.1/16")}},
+		{Name: "eth1", Addrs: []*net.IPNet{network("192.168.2.10/24")}},
+		{Name: "eth0", Flags: net.FlagUp, Addrs: []*net.IPNet{
+			network("fe80::1/64"), network("169.254.0.5/16"), network("203.0.113.7/24"), network("10.1.2.3/8"),
+		}},
+	}
+	ip, err := selectLanIP(ifaces)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.1.2.3", ip.String())
+	ip, err = selectLanIP(ifaces[:2])
+	assert.Nil(t, ip)
+	assert.True(t, errors.Is(err, ErrIPNotFound))
+
+	addrs := filterInterfaceAddrs(ifaces, InterfaceAddrFilter{Family: IPv6})
+	assert.Equal(t, 2, len(addrs))
+	assert.Equal(t, "lo", addrs[0].Interface.Name)
+	addrs = filterInterfaceAddrs(ifaces, InterfaceAddrFilter{Scopes: []IPScope{IPScopePrivate}, Include: []string{"eth*"}})
+	assert.Equal(t, 2, len(addrs))
+	assert.Equal(t, "192.168.2.10", addrs[0].IP.String())
+	addrs = filterInterfaceAddrs(ifaces, InterfaceAddrFilter{Scopes: []IPScope{IPScopeGlobal}, UpOnly: true})
+	assert.Equal(t, 1, len(addrs))
+	assert.Equal(t, "203.0.113.7/24", addrs[0].Network.String())
+	assert.True(t, ifaces[1].IsVirtual())
+}
+
+func TestNetworks(t *testing.T) {
+	outer, err := ParseNetwork("10.0.0.0/8")
+	assert.Nil(t, err)
+	inner, _ := ParseNetwork("10.1.0.0/16")
+	single, _ := ParseNetwork("10.1.2.3")
+	other, _ := ParseNetwork("192.168.0.0/16")
+	assert.Equal(t, "10.1.2.3/32", single.String())
+	assert.True(t, NetworkContains(outer, inner))
+	assert.True(t, NetworkContains(inner, single))
+	assert.False(t, NetworkContains(inner, outer))
+	assert.True(t, NetworksOverlap(inner, outer))
+	assert.False(t, NetworksOverlap(outer, other))
+	_, err = ParseNetwork("10.0.0.0/33")
+	assert.NotNil(t, err)
+
+	subnets, err := SplitNetwork(inner, 18)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.1.0.0/18", "10.1.64.0/18", "10.1.128.0/18", "10.1.192.0/18"},
+		[]string{subnets[0].String(), subnets[1].String(), subnets[2].String(), subnets[3].String()})
+	v6, _ := ParseNetwork("2001:db8::/32")
+	subnets, err = SplitNetwork(v6, 34)
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8:c000::/34", subnets[3].String())
+	_, err = SplitNetwork(inner, 8)
+	assert.NotNil(t, err)
+	_, err = SplitNetwork(v6, 64)
+	assert.NotNil(t, err)
+}
+
+func TestIPRange(t *testing.T) {
+	r, err := ParseIPRange("10.0.0.254 - 10.0.1.1")
+	assert.Nil(t, err)
+	var ips []string
+	r.Each(func(ip net.IP) bool {
+		ips = append(ips, ip.String())
+		return true
+	})
+	assert.Equal(t, []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}, ips)
+	assert.Equal(t, int64(4), r.Size().Int64())
+	assert.True(t, r.Contains(net.ParseIP("10.0.0.255")))
+	assert.False(t, r.Contains(net.ParseIP("10.0.1.2")))
+	_, err = ParseIPRange("10.0.0.2-10.0.0.1")
+	assert.NotNil(t, err)
+
+	r, err = ParseIPRange("2001:db8::/126")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::-2001:db8::3", r.String())
+	assert.Nil(t, NextIP(net.ParseIP("255.255.255.255")))
+	assert.Nil(t, PrevIP(net.ParseIP("::")))
+	assert.Equal(t, "9.255.255.255", PrevIP(net.ParseIP("10.0.0.0")).String())
+
+	// the last range of a family ends the iteration
+	r, _ = ParseIPRange("255.255.255.254/31")
+	count := 0
+	r.Each(func(ip net.IP) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 2, count)
+}
+
+func TestLocalIPs(t *testing.T) {
+	ifaces, err := NetInterfaces()
+	assert.Nil(t, err)
+	assert.True(t, len(ifaces) > 0)
+	if ip, err := PreferredOutboundIP(IPv4); err == nil {
+		assert.NotNil(t, ip.To4())
+	}
+}
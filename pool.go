@@ -1,25 +1,197 @@
 package utility
 
-import "sync"
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
 
+const (
+	bytesPoolMinClass = 6  // 64B
+	bytesPoolMaxClass = 30 // 1GiB
+
+	// DefaultBytesPoolMaxSize is the largest capacity BytesPool keeps if MaxPooledSize is not set.
+	DefaultBytesPoolMaxSize = 1 << 20
+
+	bytesPoolPoison = 0xdb
+)
+
+// BytesPoolStats are the counters of a BytesPool since it was created.
+type BytesPoolStats struct {
+	// Hits are Get calls served by a pooled buffer, Misses the ones that allocated.
+	Hits   int64
+	Misses int64
+	// Allocs counts allocated buffers, i.e. Misses plus Gets larger than MaxPooledSize.
+	Allocs int64
+	Puts   int64
+	// Drops are buffers not kept by Put: too small or larger than MaxPooledSize.
+	Drops int64
+	// DoublePuts and UseAfterPuts are only detected in debug mode.
+	DoublePuts   int64
+	UseAfterPuts int64
+}
+
+// BytesPool pools []byte in power-of-two size classes from 64B to MaxPooledSize,
+// so a Get is served by a buffer of the class its minCap rounds up to.
+// The zero value is ready to use.
+//
+// In debug mode (Debug set before first use) buffers are kept on plain free
+// lists instead of sync.Pool, Put panics on a buffer already put back, and Get
+// panics if a pooled buffer was written to after Put. Set OnMisuse to report
+// those errors instead.
 type BytesPool struct {
-	pool sync.Pool
+	stats BytesPoolStats
+
+	// MaxPooledSize is the largest capacity kept by Put, default DefaultBytesPoolMaxSize,
+	// at most 1GiB.
+	MaxPooledSize int
+	Debug         bool
+	OnMisuse      func(err error)
+
+	// classes hold *[]byte, not to allocate a slice header on every Put, and headers recycles
+	// the pointers of the buffers taken by Get.
+	classes [bytesPoolMaxClass + 1]sync.Pool
+	headers sync.Pool
+
+	// debug mode state
+	lock     sync.Mutex
+	free     [bytesPoolMaxClass + 1][][]byte
+	returned map[uintptr]bool
 }
 
+// Get returns an empty slice with a capacity of at least minCap.
 func (p *BytesPool) Get(minCap int) []byte {
-	v := p.pool.Get()
-	if v == nil {
+	if minCap > p.maxPooledSize() {
+		atomic.AddInt64(&p.stats.Allocs, 1)
 		return make([]byte, 0, minCap)
 	}
-	bs := v.([]byte)
-	if cap(bs) < minCap {
-		bs = make([]byte, 0, minCap)
+	class := bytesPoolClassOf(minCap)
+	var bs []byte
+	if p.Debug {
+		var err error
+		if bs, err = p.debugGet(class); err != nil {
+			p.misuse(err)
+		}
+	} else if v := p.classes[class].Get(); v != nil {
+		header := v.(*[]byte)
+		bs = *header
+		*header = nil
+		p.headers.Put(header)
+	}
+	if bs == nil {
+		atomic.AddInt64(&p.stats.Misses, 1)
+		atomic.AddInt64(&p.stats.Allocs, 1)
+		return make([]byte, 0, 1<<class)
 	}
+	atomic.AddInt64(&p.stats.Hits, 1)
 	return bs
 }
 
+// Put returns v to the pool, v must not be used afterwards.
 func (p *BytesPool) Put(v []byte) {
-	if v != nil {
-		p.pool.Put(v[:0])
+	if v == nil {
+		return
+	}
+	atomic.AddInt64(&p.stats.Puts, 1)
+	size := cap(v)
+	if size < 1<<bytesPoolMinClass || size > p.maxPooledSize() {
+		atomic.AddInt64(&p.stats.Drops, 1)
+		return
+	}
+	// round down, so every buffer of a class is large enough for it
+	class := bits.Len(uint(size)) - 1
+	if p.Debug {
+		if err := p.debugPut(class, v[:0]); err != nil {
+			p.misuse(err)
+		}
+		return
+	}
+	header, _ := p.headers.Get().(*[]byte)
+	if header == nil {
+		header = new([]byte)
+	}
+	*header = v[:0]
+	p.classes[class].Put(header)
+}
+
+func (p *BytesPool) Stats() BytesPoolStats {
+	return BytesPoolStats{
+		Hits:         atomic.LoadInt64(&p.stats.Hits),
+		Misses:       atomic.LoadInt64(&p.stats.Misses),
+		Allocs:       atomic.LoadInt64(&p.stats.Allocs),
+		Puts:         atomic.LoadInt64(&p.stats.Puts),
+		Drops:        atomic.LoadInt64(&p.stats.Drops),
+		DoublePuts:   atomic.LoadInt64(&p.stats.DoublePuts),
+		UseAfterPuts: atomic.LoadInt64(&p.stats.UseAfterPuts),
+	}
+}
+
+func (p *BytesPool) maxPooledSize() int {
+	if p.MaxPooledSize > 1<<bytesPoolMaxClass {
+		return 1 << bytesPoolMaxClass
+	}
+	if p.MaxPooledSize > 0 {
+		return p.MaxPooledSize
+	}
+	return DefaultBytesPoolMaxSize
+}
+
+func bytesPoolClassOf(size int) int {
+	if size <= 1<<bytesPoolMinClass {
+		return bytesPoolMinClass
 	}
+	return bits.Len(uint(size - 1))
+}
+
+func (p *BytesPool) debugGet(class int) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := len(p.free[class])
+	if n == 0 {
+		return nil, nil
+	}
+	bs := p.free[class][n-1]
+	p.free[class] = p.free[class][:n-1]
+	delete(p.returned, bytesAddress(bs))
+	for _, b := range bs[:cap(bs)] {
+		if b != bytesPoolPoison {
+			atomic.AddInt64(&p.stats.UseAfterPuts, 1)
+			return bs, fmt.Errorf("bytes pool: buffer %#x of cap %d was written after Put", bytesAddress(bs), cap(bs))
+		}
+	}
+	return bs, nil
+}
+
+func (p *BytesPool) debugPut(class int, bs []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	addr := bytesAddress(bs)
+	if p.returned[addr] {
+		atomic.AddInt64(&p.stats.DoublePuts, 1)
+		return fmt.Errorf("bytes pool: buffer %#x of cap %d was put twice", addr, cap(bs))
+	}
+	if p.returned == nil {
+		p.returned = make(map[uintptr]bool)
+	}
+	p.returned[addr] = true
+	full := bs[:cap(bs)]
+	for i := range full {
+		full[i] = bytesPoolPoison
+	}
+	p.free[class] = append(p.free[class], bs)
+	return nil
+}
+
+func (p *BytesPool) misuse(err error) {
+	if p.OnMisuse != nil {
+		p.OnMisuse(err)
+		return
+	}
+	panic(err)
+}
+
+func bytesAddress(bs []byte) uintptr {
+	return uintptr(unsafe.Pointer(&bs[:cap(bs)][0]))
 }
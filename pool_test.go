@@ -1,6 +1,8 @@
 package utility
 
 import (
+	"math"
+	"math/bits"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,7 +14,7 @@ func TestPool(t *testing.T) {
 
 	bs := pool.Get(poolCapacity)
 	assert.NotNil(t, bs)
-	assert.Equal(t, cap(bs), poolCapacity)
+	assert.Equal(t, cap(bs), 64)
 	bs = append(bs, []byte("1234567890abcdefghijklmnopqrstuvwxyz")...)
 	pool.Put(bs)
 
@@ -20,3 +22,63 @@ func TestPool(t *testing.T) {
 	assert.Equal(t, 0, len(bs2))
 	assert.Greater(t, cap(bs2), poolCapacity)
 }
+
+func TestPoolSizeClasses(t *testing.T) {
+	pool := BytesPool{MaxPooledSize: 4096, Debug: true}
+	small := pool.Get(100)
+	assert.Equal(t, 128, cap(small))
+	pool.Put(small)
+	assert.Equal(t, 128, cap(pool.Get(65)))
+	assert.Equal(t, 256, cap(pool.Get(129)))
+
+	huge := pool.Get(10000)
+	assert.Equal(t, 10000, cap(huge))
+	pool.Put(huge)
+	pool.Put(make([]byte, 10))
+	assert.Equal(t, BytesPoolStats{Hits: 1, Misses: 2, Allocs: 3, Puts: 3, Drops: 2}, pool.Stats())
+}
+
+func TestPoolMaxPooledSizeClamped(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("needs buffers larger than 1GiB")
+	}
+	for _, debug := range []bool{false, true} {
+		pool := BytesPool{MaxPooledSize: math.MaxInt, Debug: debug}
+		huge := pool.Get(1<<30 + 1)
+		assert.Equal(t, 1<<30+1, cap(huge))
+		pool.Put(huge)
+		assert.Equal(t, BytesPoolStats{Allocs: 1, Puts: 1, Drops: 1}, pool.Stats())
+	}
+}
+
+func TestPoolDebug(t *testing.T) {
+	var misuses []error
+	pool := BytesPool{Debug: true, OnMisuse: func(err error) {
+		misuses = append(misuses, err)
+	}}
+	bs := pool.Get(64)
+	pool.Put(bs)
+	pool.Put(bs)
+	assert.Equal(t, 1, len(misuses))
+	assert.Equal(t, int64(1), pool.Stats().DoublePuts)
+
+	bs = append(bs, 'x')
+	_ = pool.Get(64)
+	assert.Equal(t, 2, len(misuses))
+	assert.Equal(t, int64(1), pool.Stats().UseAfterPuts)
+
+	assert.Panics(t, func() {
+		strict := BytesPool{Debug: true}
+		bs := strict.Get(64)
+		strict.Put(bs)
+		strict.Put(bs)
+	})
+}
+
+func BenchmarkBytesPool(b *testing.B) {
+	var pool BytesPool
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pool.Put(pool.Get(1024))
+	}
+}
@@ -0,0 +1,531 @@
+package utility
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HTTPHeaderRetryAfter         = "Retry-After"
+	HTTPHeaderRateLimitLimit     = "RateLimit-Limit"
+	HTTPHeaderRateLimitRemaining = "RateLimit-Remaining"
+	HTTPHeaderRateLimitReset     = "RateLimit-Reset"
+
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+
+	keyedRateLimiterShards = 64
+)
+
+var ErrRateLimited = NewError("rate limited").WithCode(ErrorCodeRateLimited).WithCategory(ErrorCategoryUnavailable)
+
+// RateLimiter decides whether an event may happen now.
+//
+//   - Allow reports whether an event may happen now, and consumes it if so.
+//   - Reserve books an event in the future, the caller waits Delay() before acting, or Cancel()s it.
+//   - Wait blocks until an event may happen, failing early if ctx would be done before.
+type RateLimiter interface {
+	Allow() bool
+	Reserve() *RateReservation
+	Wait(ctx context.Context) error
+	Status() RateLimitStatus
+}
+
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	// Reset is when the limiter is back to its full capacity.
+	Reset time.Duration
+}
+
+type RateReservation struct {
+	ok     bool
+	delay  time.Duration
+	cancel func()
+	once   sync.Once
+}
+
+// OK reports whether the event could be reserved, an event the limiter can never allow is not OK.
+func (r *RateReservation) OK() bool {
+	return r.ok
+}
+
+func (r *RateReservation) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel gives the reserved event back to the limiter.
+func (r *RateReservation) Cancel() {
+	if !r.ok || r.cancel == nil {
+		return
+	}
+	r.once.Do(r.cancel)
+}
+
+// rateReserver is the core shared by the limiters: reserve an event at now
+// if it doesn't have to wait longer than maxWait.
+type rateReserver interface {
+	reserve(now time.Time, maxWait time.Duration) *RateReservation
+}
+
+func allowRate(r rateReserver) bool {
+	return r.reserve(time.Now(), 0).ok
+}
+
+func reserveRate(r rateReserver) *RateReservation {
+	return r.reserve(time.Now(), math.MaxInt64)
+}
+
+func waitRate(ctx context.Context, r rateReserver) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	maxWait := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+	reservation := r.reserve(now, maxWait)
+	if !reservation.ok {
+		return ErrRateLimited
+	}
+	if reservation.delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(reservation.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+// TokenBucket refills rate tokens per second up to burst, every event takes one token.
+type TokenBucket struct {
+	rate  float64
+	burst int
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket treats a rate that isn't positive as 0: the bucket never refills,
+// only its first burst events are allowed.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if !(rate > 0) {
+		rate = 0
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+func (b *TokenBucket) Allow() bool {
+	return allowRate(b)
+}
+
+func (b *TokenBucket) Reserve() *RateReservation {
+	return reserveRate(b)
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return waitRate(ctx, b)
+}
+
+func (b *TokenBucket) Status() RateLimitStatus {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(time.Now())
+	status := RateLimitStatus{Limit: b.burst}
+	if b.tokens > 0 {
+		status.Remaining = int(b.tokens)
+	}
+	if missing := float64(b.burst) - b.tokens; missing > 0 && b.rate > 0 {
+		status.Reset = time.Duration(missing / b.rate * float64(time.Second))
+	}
+	return status
+}
+
+func (b *TokenBucket) reserve(now time.Time, maxWait time.Duration) *RateReservation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(now)
+	var delay time.Duration
+	if b.tokens < 1 {
+		if b.rate <= 0 {
+			return &RateReservation{}
+		}
+		delay = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if delay > maxWait {
+		return &RateReservation{}
+	}
+	b.tokens--
+	return &RateReservation{ok: true, delay: delay, cancel: func() {
+		b.lock.Lock()
+		b.tokens = math.Min(b.tokens+1, float64(b.burst))
+		b.lock.Unlock()
+	}}
+}
+
+func (b *TokenBucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.tokens+elapsed.Seconds()*b.rate, float64(b.burst))
+		b.last = now
+	}
+}
+
+// LeakyBucket lets events out evenly at rate per second, at most capacity events may queue.
+// Unlike TokenBucket it doesn't allow bursts: Allow only succeeds when nothing is queued.
+type LeakyBucket struct {
+	interval time.Duration
+	capacity int
+
+	lock sync.Mutex
+	// next is when the next event may leak out
+	next time.Time
+}
+
+// NewLeakyBucket treats a rate that isn't positive as 0, as NewTokenBucket does: nothing
+// leaks out, only the first event is allowed.
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	if capacity < 1 {
+		capacity = 1
+	}
+	b := &LeakyBucket{capacity: capacity}
+	if rate > 0 {
+		b.interval = time.Duration(float64(time.Second) / rate)
+		if b.interval < 1 {
+			b.interval = 1
+		}
+	}
+	return b
+}
+
+func (b *LeakyBucket) Allow() bool {
+	return allowRate(b)
+}
+
+func (b *LeakyBucket) Reserve() *RateReservation {
+	return reserveRate(b)
+}
+
+func (b *LeakyBucket) Wait(ctx context.Context) error {
+	return waitRate(ctx, b)
+}
+
+func (b *LeakyBucket) Status() RateLimitStatus {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	status := RateLimitStatus{Limit: b.capacity, Remaining: b.capacity}
+	if b.interval == 0 {
+		if !b.next.IsZero() {
+			status.Remaining = 0
+		}
+		return status
+	}
+	if pending := b.next.Sub(time.Now()); pending > 0 {
+		queued := int((pending + b.interval - 1) / b.interval)
+		status.Remaining = b.capacity - queued
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+		status.Reset = pending
+	}
+	return status
+}
+
+func (b *LeakyBucket) reserve(now time.Time, maxWait time.Duration) *RateReservation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.interval == 0 {
+		return b.reserveOnce(now)
+	}
+	at := b.next
+	if at.Before(now) {
+		at = now
+	}
+	delay := at.Sub(now)
+	if delay > maxWait || delay > time.Duration(b.capacity)*b.interval {
+		return &RateReservation{}
+	}
+	b.next = at.Add(b.interval)
+	return &RateReservation{ok: true, delay: delay, cancel: func() {
+		b.lock.Lock()
+		b.next = b.next.Add(-b.interval)
+		b.lock.Unlock()
+	}}
+}
+
+// reserveOnce lets the first event out of a bucket that never leaks.
+func (b *LeakyBucket) reserveOnce(now time.Time) *RateReservation {
+	if !b.next.IsZero() {
+		return &RateReservation{}
+	}
+	b.next = now
+	return &RateReservation{ok: true, cancel: func() {
+		b.lock.Lock()
+		b.next = time.Time{}
+		b.lock.Unlock()
+	}}
+}
+
+// SlidingWindowLog allows at most limit events in any window, it logs the time of every event.
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+
+	lock sync.Mutex
+	// events sorted by time, including reserved future events
+	events []time.Time
+}
+
+func NewSlidingWindowLog(limit int, window time.Duration) *SlidingWindowLog {
+	if limit < 1 {
+		limit = 1
+	}
+	return &SlidingWindowLog{limit: limit, window: window}
+}
+
+func (l *SlidingWindowLog) Allow() bool {
+	return allowRate(l)
+}
+
+func (l *SlidingWindowLog) Reserve() *RateReservation {
+	return reserveRate(l)
+}
+
+func (l *SlidingWindowLog) Wait(ctx context.Context) error {
+	return waitRate(ctx, l)
+}
+
+func (l *SlidingWindowLog) Status() RateLimitStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.expire(now)
+	status := RateLimitStatus{Limit: l.limit, Remaining: l.limit - len(l.events)}
+	if status.Remaining < 0 {
+		status.Remaining = 0
+	}
+	if n := len(l.events); n > 0 {
+		status.Reset = l.events[n-1].Add(l.window).Sub(now)
+	}
+	return status
+}
+
+func (l *SlidingWindowLog) reserve(now time.Time, maxWait time.Duration) *RateReservation {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.expire(now)
+	at := now
+	if n := len(l.events); n >= l.limit {
+		// the event has to wait until the limit-th latest event leaves the window
+		if free := l.events[n-l.limit].Add(l.window); free.After(at) {
+			at = free
+		}
+	}
+	if n := len(l.events); n > 0 && l.events[n-1].After(at) {
+		at = l.events[n-1]
+	}
+	delay := at.Sub(now)
+	if delay > maxWait {
+		return &RateReservation{}
+	}
+	l.events = append(l.events, at)
+	return &RateReservation{ok: true, delay: delay, cancel: func() {
+		l.lock.Lock()
+		for i := len(l.events) - 1; i >= 0; i-- {
+			if l.events[i].Equal(at) {
+				l.events = append(l.events[:i], l.events[i+1:]...)
+				break
+			}
+		}
+		l.lock.Unlock()
+	}}
+}
+
+func (l *SlidingWindowLog) expire(now time.Time) {
+	i := 0
+	for i < len(l.events) && now.Sub(l.events[i]) >= l.window {
+		i++
+	}
+	if i > 0 {
+		l.events = append(l.events[:0], l.events[i:]...)
+	}
+}
+
+type keyedRateLimiterEntry struct {
+	limiter RateLimiter
+	// lastUsed in unix nanoseconds
+	lastUsed int64
+}
+
+type keyedRateLimiterShard struct {
+	lock    sync.RWMutex
+	entries map[string]*keyedRateLimiterEntry
+}
+
+// KeyedRateLimiter keeps one RateLimiter per key, e.g. per user or API key.
+// Keys are spread over shards to reduce lock contention, and limiters unused
+// for idleTimeout are evicted so the number of keys can grow large.
+type KeyedRateLimiter struct {
+	factory     func(key string) RateLimiter
+	idleTimeout time.Duration
+	shards      [keyedRateLimiterShards]keyedRateLimiterShard
+	closing     chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewKeyedRateLimiter creates limiters with factory on first use of a key.
+// If idleTimeout > 0 a background goroutine evicts idle limiters until Close is called.
+func NewKeyedRateLimiter(factory func(key string) RateLimiter, idleTimeout time.Duration) *KeyedRateLimiter {
+	l := &KeyedRateLimiter{factory: factory, idleTimeout: idleTimeout, closing: make(chan struct{})}
+	for i := range l.shards {
+		l.shards[i].entries = make(map[string]*keyedRateLimiterEntry)
+	}
+	if idleTimeout > 0 {
+		go l.evictLoop()
+	}
+	return l
+}
+
+// Limiter returns the limiter of key, creating it if needed.
+func (l *KeyedRateLimiter) Limiter(key string) RateLimiter {
+	shard := l.shard(key)
+	now := time.Now().UnixNano()
+	shard.lock.RLock()
+	entry, ok := shard.entries[key]
+	shard.lock.RUnlock()
+	if !ok {
+		shard.lock.Lock()
+		if entry, ok = shard.entries[key]; !ok {
+			entry = &keyedRateLimiterEntry{limiter: l.factory(key), lastUsed: now}
+			shard.entries[key] = entry
+		}
+		shard.lock.Unlock()
+	}
+	atomic.StoreInt64(&entry.lastUsed, now)
+	return entry.limiter
+}
+
+func (l *KeyedRateLimiter) Allow(key string) bool {
+	return l.Limiter(key).Allow()
+}
+
+func (l *KeyedRateLimiter) Reserve(key string) *RateReservation {
+	return l.Limiter(key).Reserve()
+}
+
+func (l *KeyedRateLimiter) Wait(ctx context.Context, key string) error {
+	return l.Limiter(key).Wait(ctx)
+}
+
+// Len returns the number of keys currently tracked.
+func (l *KeyedRateLimiter) Len() int {
+	count := 0
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.lock.RLock()
+		count += len(shard.entries)
+		shard.lock.RUnlock()
+	}
+	return count
+}
+
+// EvictIdle removes the limiters unused since idleTimeout and returns how many were removed.
+func (l *KeyedRateLimiter) EvictIdle(idleTimeout time.Duration) int {
+	deadline := time.Now().Add(-idleTimeout).UnixNano()
+	count := 0
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.lock.Lock()
+		for key, entry := range shard.entries {
+			if atomic.LoadInt64(&entry.lastUsed) <= deadline {
+				delete(shard.entries, key)
+				count++
+			}
+		}
+		shard.lock.Unlock()
+	}
+	return count
+}
+
+// Close stops the background eviction.
+func (l *KeyedRateLimiter) Close() {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+	})
+}
+
+func (l *KeyedRateLimiter) evictLoop() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.EvictIdle(l.idleTimeout)
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+func (l *KeyedRateLimiter) shard(key string) *keyedRateLimiterShard {
+	h := fnv.New32a()
+	_, _ = h.Write(StringToBytes(key))
+	return &l.shards[h.Sum32()%keyedRateLimiterShards]
+}
+
+// NewRateLimitMiddleware rejects requests over the limit of their key with 429 Too Many Requests.
+// keyFunc defaults to the client IP. Every response carries the RateLimit-* headers,
+// rejected ones also Retry-After.
+func NewRateLimitMiddleware(limiter *KeyedRateLimiter, keyFunc func(req *http.Request) string) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = func(req *http.Request) string {
+			if ip := RequestRemoteIP(req); ip != nil {
+				return ip.String()
+			}
+			return req.RemoteAddr
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rl := limiter.Limiter(keyFunc(req))
+			allowed := rl.Allow()
+			status := rl.Status()
+			header := w.Header()
+			header.Set(HTTPHeaderRateLimitLimit, strconv.Itoa(status.Limit))
+			header.Set(HTTPHeaderRateLimitRemaining, strconv.Itoa(status.Remaining))
+			header.Set(HTTPHeaderRateLimitReset, strconv.Itoa(ceilSeconds(status.Reset)))
+			if !allowed {
+				retryAfter := 1
+				if reservation := rl.Reserve(); reservation.OK() {
+					retryAfter = ceilSeconds(reservation.Delay())
+					reservation.Cancel()
+				}
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				header.Set(HTTPHeaderRetryAfter, strconv.Itoa(retryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
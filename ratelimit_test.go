@@ -0,0 +1,128 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(100, 2)
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+	assert.Equal(t, 2, bucket.Status().Limit)
+
+	reservation := bucket.Reserve()
+	assert.True(t, reservation.OK())
+	assert.True(t, reservation.Delay() > 0 && reservation.Delay() <= 10*time.Millisecond)
+	reservation.Cancel()
+
+	assert.Nil(t, bucket.Wait(context.Background()))
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		bucket := NewTokenBucket(rate, 1)
+		assert.True(t, bucket.Allow(), rate)
+		assert.False(t, bucket.Allow(), rate)
+		assert.False(t, bucket.Reserve().OK(), rate)
+		assert.Equal(t, RateLimitStatus{Limit: 1}, bucket.Status(), rate)
+	}
+
+	slow := NewTokenBucket(1, 1)
+	assert.True(t, slow.Allow())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(slow.Wait(ctx), ErrRateLimited))
+}
+
+func TestLeakyBucket(t *testing.T) {
+	bucket := NewLeakyBucket(100, 3)
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+	r1, r2, r3 := bucket.Reserve(), bucket.Reserve(), bucket.Reserve()
+	assert.True(t, r1.OK() && r2.OK() && r3.OK())
+	assert.True(t, r2.Delay() > r1.Delay())
+	assert.False(t, bucket.Reserve().OK())
+	assert.Equal(t, 0, bucket.Status().Remaining)
+	r2.Cancel()
+	assert.True(t, bucket.Reserve().OK())
+
+	// without a rate nothing leaks out after the first event
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		bucket := NewLeakyBucket(rate, 3)
+		reservation := bucket.Reserve()
+		assert.True(t, reservation.OK() && reservation.Delay() == 0, rate)
+		assert.False(t, bucket.Allow(), rate)
+		assert.Equal(t, 0, bucket.Status().Remaining, rate)
+		reservation.Cancel()
+		assert.True(t, bucket.Allow(), rate)
+		assert.False(t, bucket.Reserve().OK(), rate)
+	}
+	assert.True(t, NewLeakyBucket(1e12, 1).Allow())
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	log := NewSlidingWindowLog(2, 20*time.Millisecond)
+	assert.True(t, log.Allow())
+	assert.True(t, log.Allow())
+	assert.False(t, log.Allow())
+	assert.Equal(t, RateLimitStatus{Limit: 2}, RateLimitStatus{Limit: log.Status().Limit, Remaining: log.Status().Remaining})
+
+	reservation := log.Reserve()
+	assert.True(t, reservation.OK())
+	assert.True(t, reservation.Delay() > 0 && reservation.Delay() <= 20*time.Millisecond)
+	reservation.Cancel()
+
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, 2, log.Status().Remaining)
+	assert.Nil(t, log.Wait(context.Background()))
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	limiter := NewKeyedRateLimiter(func(key string) RateLimiter {
+		return NewTokenBucket(1, 1)
+	}, 0)
+	defer limiter.Close()
+	assert.True(t, limiter.Allow("a"))
+	assert.False(t, limiter.Allow("a"))
+	assert.True(t, limiter.Allow("b"))
+	assert.Equal(t, 2, limiter.Len())
+	assert.Equal(t, 0, limiter.EvictIdle(time.Hour))
+	assert.Equal(t, 2, limiter.EvictIdle(0))
+	assert.Equal(t, 0, limiter.Len())
+	assert.True(t, limiter.Allow("a"))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := NewKeyedRateLimiter(func(key string) RateLimiter {
+		return NewTokenBucket(0.5, 1)
+	}, time.Minute)
+	defer limiter.Close()
+	handler := NewRateLimitMiddleware(limiter, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(HTTPHeaderRateLimitLimit))
+	assert.Equal(t, "0", rec.Header().Get(HTTPHeaderRateLimitRemaining))
+	assert.Equal(t, "2", rec.Header().Get(HTTPHeaderRateLimitReset))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(HTTPHeaderRetryAfter))
+
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
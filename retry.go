@@ -0,0 +1,262 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	ErrorCodeRetryExhausted ErrorCode = "retry_exhausted"
+
+	defaultRetryMaxAttempts = 3
+	// defaultRetryMaxBackoff caps the backoffs without Max, so that delays don't overflow.
+	defaultRetryMaxBackoff = time.Hour
+)
+
+var ErrRetryExhausted = NewError("retry exhausted").WithCode(ErrorCodeRetryExhausted)
+
+// Backoff computes the delay before the next attempt.
+// attempt starts at 1 for the delay after the first failure, prev is the previous delay (0 at first).
+type Backoff interface {
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+type BackoffFunc func(attempt int, prev time.Duration) time.Duration
+
+func (f BackoffFunc) Next(attempt int, prev time.Duration) time.Duration {
+	return f(attempt, prev)
+}
+
+// ConstantBackoff waits the same delay between attempts.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return BackoffFunc(func(int, time.Duration) time.Duration {
+		return delay
+	})
+}
+
+// ExponentialBackoff waits Initial * Multiplier^(attempt-1), capped at Max, an hour if 0.
+// Jitter in [0, 1] randomizes the delay within ±Jitter of its value.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+func (b ExponentialBackoff) Next(attempt int, _ time.Duration) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	maxDelay := b.Max
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxBackoff
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if b.Jitter > 0 {
+		spread := delay * math.Min(b.Jitter, 1)
+		delay = float64(randomDuration(time.Duration(delay-spread), time.Duration(delay+spread)))
+	}
+	return time.Duration(delay)
+}
+
+// DecorrelatedJitterBackoff waits a random delay in [Base, prev*3], capped at Max, an hour if 0.
+// ref: https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Next(_ int, prev time.Duration) time.Duration {
+	maxDelay := b.Max
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxBackoff
+	}
+	if prev < b.Base {
+		prev = b.Base
+	}
+	if prev > maxDelay {
+		prev = maxDelay
+	}
+	upper := time.Duration(math.MaxInt64)
+	if prev <= upper/3 {
+		upper = prev * 3
+	}
+	delay := randomDuration(b.Base, upper)
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// randomDuration returns a random delay in [minDelay, maxDelay], in int64 as hours overflow an
+// int on 32-bit platforms.
+func randomDuration(minDelay, maxDelay time.Duration) time.Duration {
+	if minDelay >= maxDelay {
+		return minDelay
+	}
+	span := int64(maxDelay - minDelay)
+	if span == math.MaxInt64 {
+		return minDelay + time.Duration(rand.Int63())
+	}
+	return minDelay + time.Duration(rand.Int63n(span+1))
+}
+
+// RetryAttempt records the outcome of one attempt.
+type RetryAttempt struct {
+	Number   int
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+	// Delay is the wait before the next attempt, 0 if there is none.
+	Delay time.Duration
+}
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 0 means 3, a negative value means no limit.
+	MaxAttempts int
+	// MaxElapsedTime stops retrying once the next attempt would start after it, 0 means no limit.
+	MaxElapsedTime time.Duration
+	// AttemptTimeout bounds each attempt through its context, 0 means no timeout.
+	AttemptTimeout time.Duration
+	// Backoff defaults to ExponentialBackoff{Initial: 100ms, Max: 10s, Jitter: 0.2}.
+	Backoff Backoff
+	// Retryable classifies errors, defaults to IsRetryableError.
+	Retryable func(err error) bool
+	// OnAttempt is called after every attempt, e.g. for logging.
+	OnAttempt func(attempt RetryAttempt)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// PermanentError marks err as not retryable.
+func PermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryableError reports false for errors marked by PermanentError, context cancellation,
+// and *Error of a category that won't change by retrying (invalid argument, not found,
+// conflict, unauthorized, forbidden), true otherwise.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch ErrorCategoryOf(err) {
+	case ErrorCategoryInvalidArgument, ErrorCategoryNotFound, ErrorCategoryConflict,
+		ErrorCategoryUnauthorized, ErrorCategoryForbidden:
+		return false
+	}
+	return true
+}
+
+// Retry calls fn until it succeeds, returns a non-retryable error, or the policy gives up.
+// It returns every attempt made. When giving up, the error wraps the last error of fn
+// and matches ErrRetryExhausted, a non-retryable error is returned unwrapped.
+func Retry(ctx context.Context, policy *RetryPolicy, fn func(ctx context.Context) error) ([]RetryAttempt, error) {
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff{Initial: 100 * time.Millisecond, Max: 10 * time.Second, Jitter: 0.2}
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+
+	var attempts []RetryAttempt
+	var delay time.Duration
+	begin := time.Now()
+	for number := 1; ; number++ {
+		attempt := RetryAttempt{Number: number, Start: time.Now()}
+		attempt.Err = callAttempt(ctx, policy.AttemptTimeout, fn)
+		attempt.Duration = time.Since(attempt.Start)
+
+		retry, giveUp := false, ""
+		switch {
+		case attempt.Err == nil || !retryable(attempt.Err):
+		case ctx.Err() != nil:
+			giveUp = "context done"
+		case maxAttempts > 0 && number >= maxAttempts:
+			giveUp = "max attempts reached"
+		default:
+			delay = backoff.Next(number, delay)
+			if policy.MaxElapsedTime > 0 && time.Since(begin)+delay > policy.MaxElapsedTime {
+				giveUp = "max elapsed time reached"
+			} else {
+				attempt.Delay = delay
+				retry = true
+			}
+		}
+		attempts = append(attempts, attempt)
+		if policy.OnAttempt != nil {
+			policy.OnAttempt(attempt)
+		}
+
+		if giveUp != "" {
+			return attempts, retryExhaustedError(attempt.Err, giveUp, number)
+		}
+		if !retry {
+			return attempts, attempt.Err
+		}
+		timer := time.NewTimer(attempt.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, retryExhaustedError(attempt.Err, "context done", number)
+		case <-timer.C:
+		}
+	}
+}
+
+func callAttempt(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(attemptCtx)
+}
+
+func retryExhaustedError(err error, reason string, attempts int) error {
+	return &Error{
+		message:  fmt.Sprintf("retry gave up after %d attempts (%s)", attempts, reason),
+		cause:    err,
+		code:     ErrorCodeRetryExhausted,
+		category: ErrorCategoryUnavailable,
+		fields:   StrMap{"attempts": attempts},
+		stack:    callers(3),
+	}
+}
@@ -0,0 +1,114 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	exp := ExponentialBackoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, exp.Next(1, 0))
+	assert.Equal(t, 40*time.Millisecond, exp.Next(3, 0))
+	assert.Equal(t, 50*time.Millisecond, exp.Next(10, 0))
+
+	exp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := exp.Next(2, 0)
+		assert.True(t, d >= 10*time.Millisecond && d <= 30*time.Millisecond, d)
+	}
+
+	decorrelated := DecorrelatedJitterBackoff{Base: time.Millisecond, Max: 20 * time.Millisecond}
+	var prev time.Duration
+	for i := 1; i < 100; i++ {
+		prev = decorrelated.Next(i, prev)
+		assert.True(t, prev >= time.Millisecond && prev <= 20*time.Millisecond, prev)
+	}
+
+	// without Max, delays are capped instead of overflowing
+	unbounded := ExponentialBackoff{Initial: time.Second, Jitter: 0.2}
+	for _, attempt := range []int{64, 100, 10000} {
+		d := unbounded.Next(attempt, 0)
+		assert.True(t, d > 0 && d <= defaultRetryMaxBackoff*6/5, d)
+	}
+	d := DecorrelatedJitterBackoff{Base: time.Second}.Next(1, time.Duration(math.MaxInt64/2))
+	assert.True(t, d > 0 && d <= defaultRetryMaxBackoff, d)
+	d = DecorrelatedJitterBackoff{Base: time.Second, Max: math.MaxInt64}.Next(1, math.MaxInt64)
+	assert.True(t, d >= time.Second, d)
+	assert.Equal(t, time.Duration(math.MaxInt64), randomDuration(math.MaxInt64, math.MaxInt64))
+	d = randomDuration(0, math.MaxInt64)
+	assert.True(t, d >= 0, d)
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	var logged []RetryAttempt
+	attempts, err := Retry(context.Background(), &RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     ConstantBackoff(time.Millisecond),
+		OnAttempt: func(attempt RetryAttempt) {
+			logged = append(logged, attempt)
+		},
+	}, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(attempts))
+	assert.Equal(t, attempts, logged)
+	assert.Equal(t, io.ErrUnexpectedEOF, attempts[0].Err)
+	assert.Equal(t, time.Millisecond, attempts[1].Delay)
+	assert.Equal(t, time.Duration(0), attempts[2].Delay)
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	attempts, err := Retry(context.Background(), &RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     ConstantBackoff(0),
+	}, func(ctx context.Context) error {
+		return io.EOF
+	})
+	assert.Equal(t, 2, len(attempts))
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.True(t, errors.Is(err, ErrRetryExhausted))
+
+	attempts, err = Retry(context.Background(), nil, func(ctx context.Context) error {
+		return PermanentError(io.EOF)
+	})
+	assert.Equal(t, 1, len(attempts))
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.False(t, errors.Is(err, ErrRetryExhausted))
+
+	attempts, err = Retry(context.Background(), &RetryPolicy{
+		MaxAttempts:    -1,
+		MaxElapsedTime: 20 * time.Millisecond,
+		Backoff:        ConstantBackoff(5 * time.Millisecond),
+	}, func(ctx context.Context) error {
+		return io.EOF
+	})
+	assert.True(t, len(attempts) > 1 && len(attempts) <= 5, len(attempts))
+	assert.True(t, errors.Is(err, ErrRetryExhausted))
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	attempts, err := Retry(ctx, &RetryPolicy{
+		AttemptTimeout: 5 * time.Millisecond,
+		Backoff:        ConstantBackoff(time.Millisecond),
+	}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, 3, len(attempts))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Nil(t, ctx.Err())
+}
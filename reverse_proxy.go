@@ -0,0 +1,414 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const ErrorCodeNoHealthyUpstream ErrorCode = "no_healthy_upstream"
+
+var ErrNoHealthyUpstream = NewError("no healthy upstream").
+	WithCode(ErrorCodeNoHealthyUpstream).WithCategory(ErrorCategoryUnavailable)
+
+// Upstream is a target of a reverse proxy.
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	unhealthy int32
+	// consecutive health check results, positive for successes
+	streak int
+	// current weight of the smooth weighted round robin
+	current int
+}
+
+// ParseUpstream parses rawURL as an upstream of weight, a weight < 1 is taken as 1.
+func ParseUpstream(rawURL string, weight int) (*Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, &url.Error{Op: "parse", URL: rawURL, Err: errors.New("upstream needs a scheme and host")}
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return &Upstream{URL: u, Weight: weight}, nil
+}
+
+func (u *Upstream) Healthy() bool {
+	return atomic.LoadInt32(&u.unhealthy) == 0
+}
+
+func (u *Upstream) setHealthy(healthy bool) {
+	var unhealthy int32
+	if !healthy {
+		unhealthy = 1
+	}
+	atomic.StoreInt32(&u.unhealthy, unhealthy)
+}
+
+// UpstreamPool selects healthy upstreams by smooth weighted round robin.
+type UpstreamPool struct {
+	lock      sync.Mutex
+	upstreams []*Upstream
+}
+
+func NewUpstreamPool(upstreams ...*Upstream) *UpstreamPool {
+	return &UpstreamPool{upstreams: upstreams}
+}
+
+func (p *UpstreamPool) Upstreams() []*Upstream {
+	return append([]*Upstream(nil), p.upstreams...)
+}
+
+// Next returns the next healthy upstream, nil if there is none.
+func (p *UpstreamPool) Next() *Upstream {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var best *Upstream
+	total := 0
+	for _, u := range p.upstreams {
+		if !u.Healthy() {
+			continue
+		}
+		u.current += u.Weight
+		total += u.Weight
+		if best == nil || u.current > best.current {
+			best = u
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+// SetHealthy marks u healthy or not, e.g. from an external health source.
+func (p *UpstreamPool) SetHealthy(u *Upstream, healthy bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	u.setHealthy(healthy)
+	u.streak = 0
+}
+
+type HealthCheckOptions struct {
+	// Path is requested on each upstream, default "/".
+	Path string
+	// Interval between checks, default 10s.
+	Interval time.Duration
+	// Timeout of a check, default 2s.
+	Timeout time.Duration
+	// Healthy judges a response, default status < 400.
+	Healthy func(resp *http.Response) bool
+	// HealthyThreshold and UnhealthyThreshold are the consecutive results changing the state, default 1.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// OnChange is called when an upstream changes state.
+	OnChange func(u *Upstream, healthy bool)
+}
+
+func (o *HealthCheckOptions) normalize() {
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.Interval <= 0 {
+		o.Interval = 10 * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Healthy == nil {
+		o.Healthy = func(resp *http.Response) bool {
+			return resp.StatusCode < http.StatusBadRequest
+		}
+	}
+	if o.HealthyThreshold < 1 {
+		o.HealthyThreshold = 1
+	}
+	if o.UnhealthyThreshold < 1 {
+		o.UnhealthyThreshold = 1
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+}
+
+// CheckHealth checks all upstreams once, concurrently.
+func (p *UpstreamPool) CheckHealth(ctx context.Context, opts HealthCheckOptions) {
+	opts.normalize()
+	var wg sync.WaitGroup
+	for _, u := range p.Upstreams() {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			p.report(u, p.check(ctx, u, &opts), &opts)
+		}(u)
+	}
+	wg.Wait()
+}
+
+// StartHealthChecks checks all upstreams every Interval until stop is called.
+func (p *UpstreamPool) StartHealthChecks(opts HealthCheckOptions) (stop func()) {
+	opts.normalize()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			p.CheckHealth(ctx, opts)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (p *UpstreamPool) check(ctx context.Context, u *Upstream, opts *HealthCheckOptions) bool {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	target := *u.URL
+	target.Path = joinURLPath(u.URL.Path, opts.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	return opts.Healthy(resp)
+}
+
+func (p *UpstreamPool) report(u *Upstream, healthy bool, opts *HealthCheckOptions) {
+	p.lock.Lock()
+	switch {
+	case healthy && u.streak < 0, !healthy && u.streak > 0:
+		u.streak = 0
+	}
+	if healthy {
+		u.streak++
+	} else {
+		u.streak--
+	}
+	changed := false
+	if healthy && !u.Healthy() && u.streak >= opts.HealthyThreshold {
+		u.setHealthy(true)
+		changed = true
+	} else if !healthy && u.Healthy() && -u.streak >= opts.UnhealthyThreshold {
+		u.setHealthy(false)
+		changed = true
+	}
+	p.lock.Unlock()
+	if changed && opts.OnChange != nil {
+		opts.OnChange(u, healthy)
+	}
+}
+
+// HeaderRewrite edits headers: Remove is applied first, then Set, then Add.
+type HeaderRewrite struct {
+	Remove []string
+	Set    http.Header
+	Add    http.Header
+}
+
+func (r *HeaderRewrite) Apply(header http.Header) {
+	for _, key := range r.Remove {
+		header.Del(key)
+	}
+	for key, values := range r.Set {
+		header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+	for key, values := range r.Add {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+}
+
+// BodyTransform rewrites a body decoded from its Content-Encoding, header can be edited as well.
+type BodyTransform func(header http.Header, body []byte) ([]byte, error)
+
+type ReverseProxyOptions struct {
+	Upstreams *UpstreamPool
+	// StripPrefix is removed from request paths, on a segment boundary, before they are joined to the upstream path.
+	StripPrefix string
+	// PreserveHost keeps the Host of the incoming request, else the upstream host is sent.
+	PreserveHost    bool
+	RequestHeaders  HeaderRewrite
+	ResponseHeaders HeaderRewrite
+	// TransformRequest and TransformResponse rewrite bodies, which are decoded before and encoded
+	// back after with the same Content-Encoding, Content-Length is updated. Bodies of an unsupported
+	// Content-Encoding are passed on untouched.
+	TransformRequest  BodyTransform
+	TransformResponse BodyTransform
+	// MaxBodySize bounds the decoded bodies given to transforms, 0 means no limit.
+	MaxBodySize int64
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// ErrorLog defaults to the standard logger.
+	ErrorLog *log.Logger
+}
+
+type upstreamKey struct{}
+
+// NewReverseProxy returns an httputil.ReverseProxy balancing requests over the healthy upstreams.
+// Requests are answered 503 Service Unavailable if no upstream is healthy, 502 Bad Gateway if the
+// upstream fails or a transform fails.
+func NewReverseProxy(opts ReverseProxyOptions) *httputil.ReverseProxy {
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	proxy := &httputil.ReverseProxy{ErrorLog: opts.ErrorLog}
+	proxy.Director = func(req *http.Request) {
+		upstream := opts.Upstreams.Next()
+		if upstream == nil {
+			// failed by the transport, Director can't fail
+			*req = *req.WithContext(context.WithValue(req.Context(), upstreamKey{}, (*Upstream)(nil)))
+			return
+		}
+		path := req.URL.Path
+		if prefix := strings.TrimRight(opts.StripPrefix, "/"); prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+			path = "/" + strings.TrimLeft(path[len(prefix):], "/")
+		}
+		req.URL.Scheme = upstream.URL.Scheme
+		req.URL.Host = upstream.URL.Host
+		req.URL.Path = joinURLPath(upstream.URL.Path, path)
+		req.URL.RawPath = ""
+		if upstream.URL.RawQuery != "" {
+			req.URL.RawQuery = strings.TrimLeft(upstream.URL.RawQuery+"&"+req.URL.RawQuery, "&")
+		}
+		if !opts.PreserveHost {
+			req.Host = upstream.URL.Host
+		}
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// keep httputil.ReverseProxy from adding the default one
+			req.Header.Set("User-Agent", "")
+		}
+		opts.RequestHeaders.Apply(req.Header)
+	}
+	proxy.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if upstream, ok := req.Context().Value(upstreamKey{}).(*Upstream); ok && upstream == nil {
+			return nil, ErrNoHealthyUpstream
+		}
+		if opts.TransformRequest != nil && req.Body != nil && req.Body != http.NoBody {
+			body, err := transformEncodedBody(req.Body, req.Header, opts.MaxBodySize, opts.TransformRequest)
+			if err != nil {
+				return nil, err
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+		return transport.RoundTrip(req)
+	})
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		opts.ResponseHeaders.Apply(resp.Header)
+		if opts.TransformResponse == nil || resp.Body == nil || resp.Body == http.NoBody ||
+			resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+			return nil
+		}
+		body, err := transformEncodedBody(resp.Body, resp.Header, opts.MaxBodySize, opts.TransformResponse)
+		if err != nil {
+			return err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrNoHealthyUpstream) {
+			status = http.StatusServiceUnavailable
+		}
+		logger := proxy.ErrorLog
+		if logger == nil {
+			logger = log.Default()
+		}
+		logger.Printf("reverse proxy %s %s: %v", req.Method, req.URL, err)
+		w.WriteHeader(status)
+	}
+	return proxy
+}
+
+// transformEncodedBody reads body decoded from the Content-Encoding of header, transforms it and
+// encodes it back. Bodies of an unsupported encoding are not transformed, bodies of an encoding
+// that can't be encoded back are sent decoded.
+// Content-Length is updated and a strong ETag removed if the body changed.
+func transformEncodedBody(body io.ReadCloser, header http.Header, maxSize int64, transform BodyTransform) ([]byte, error) {
+	defer body.Close()
+	contentEncoding := strings.Join(header.Values(HTTPHeaderContentEncoding), ",")
+	decoder, err := NewContentDecodingReader(body, contentEncoding, maxSize)
+	if errors.Is(err, ErrUnsupportedContentEncoding) {
+		// passed on untouched
+		return ReadBytes(body)
+	} else if err != nil {
+		return nil, err
+	}
+	decoded, err := ReadBytes(decoder)
+	if err != nil {
+		return nil, err
+	}
+	transformed, err := transform(header, decoded)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(transformed, decoded) {
+		if etag := header.Get(HTTPHeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Del(HTTPHeaderETag)
+		}
+	}
+	// the transform may change the encoding
+	contentEncoding = strings.Join(header.Values(HTTPHeaderContentEncoding), ",")
+	encoded := transformed
+	for _, token := range ParseContentEncoding(contentEncoding) {
+		codec := CodecByContentEncoding(token)
+		if codec == nil || !codec.CanEncode() {
+			encoded = transformed
+			header.Del(HTTPHeaderContentEncoding)
+			break
+		}
+		if encoded, err = codec.Encode(encoded, CodecDefaultLevel); err != nil {
+			return nil, err
+		}
+	}
+	header.Set(HTTPHeaderContentLength, strconv.Itoa(len(encoded)))
+	return encoded, nil
+}
+
+func joinURLPath(base, path string) string {
+	switch {
+	case base == "" || base == "/":
+		if strings.HasPrefix(path, "/") {
+			return path
+		}
+		return "/" + path
+	case path == "" || path == "/":
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
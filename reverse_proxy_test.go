@@ -0,0 +1,154 @@
+package utility
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		raw, _ := io.ReadAll(req.Body)
+		body, _ := ReadDecodedBodyWithHeader(raw, req.Header.Get(HTTPHeaderContentEncoding))
+		w.Header().Set(HTTPHeaderContentType, ContentTypeJSON)
+		w.Header().Set("X-Internal", "1")
+		w.Header().Set(HTTPHeaderETag, `"v1"`)
+		w.Header().Set(HTTPHeaderContentEncoding, "gzip")
+		zw := gzip.NewWriter(w)
+		_ = json.NewEncoder(zw).Encode(StrMap{
+			"path":   req.URL.Path,
+			"host":   req.Host,
+			"tenant": req.Header.Get("X-Tenant"),
+			"body":   string(body),
+			"length": req.ContentLength,
+		})
+		_ = zw.Close()
+	}))
+	defer backend.Close()
+
+	upstream, err := ParseUpstream(backend.URL+"/v1", 1)
+	assert.Nil(t, err)
+	proxy := NewReverseProxy(ReverseProxyOptions{
+		Upstreams:       NewUpstreamPool(upstream),
+		StripPrefix:     "/api/",
+		RequestHeaders:  HeaderRewrite{Set: http.Header{"X-Tenant": {"t1"}}},
+		ResponseHeaders: HeaderRewrite{Remove: []string{"X-Internal"}},
+		TransformRequest: func(header http.Header, body []byte) ([]byte, error) {
+			return bytes.ToUpper(body), nil
+		},
+		TransformResponse: func(header http.Header, body []byte) ([]byte, error) {
+			var m StrMap
+			if err := json.Unmarshal(body, &m); err != nil {
+				return nil, err
+			}
+			m["proxied"] = true
+			return json.Marshal(m)
+		},
+	})
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write([]byte("hello"))
+	_ = zw.Close()
+	req, _ := http.NewRequest(http.MethodPost, front.URL+"/api/users", &gzipped)
+	req.Header.Set(HTTPHeaderContentEncoding, "gzip")
+	req.Header.Set(HTTPHeaderAcceptEncoding, "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "gzip", resp.Header.Get(HTTPHeaderContentEncoding))
+	assert.Equal(t, strconv.Itoa(len(raw)), resp.Header.Get(HTTPHeaderContentLength))
+	assert.Equal(t, "", resp.Header.Get("X-Internal"))
+	assert.Equal(t, "", resp.Header.Get(HTTPHeaderETag))
+	body, err := ReadDecodedBodyWithHeader(raw, "gzip")
+	assert.Nil(t, err)
+	var m StrMap
+	assert.Nil(t, json.Unmarshal(body, &m))
+	assert.Equal(t, "/v1/users", m["path"])
+	assert.Equal(t, upstream.URL.Host, m["host"])
+	assert.Equal(t, "t1", m["tenant"])
+	assert.Equal(t, "HELLO", m["body"])
+	assert.Equal(t, true, m["proxied"])
+
+	resp, err = http.Get(front.URL + "/apiary")
+	assert.Nil(t, err)
+	body, _ = ReadDecodedResponseBody(resp)
+	assert.Nil(t, json.Unmarshal(body, &m))
+	assert.Equal(t, "/v1/apiary", m["path"])
+
+	// no healthy upstream
+	proxy.ErrorLog = log.New(io.Discard, "", 0)
+	NewUpstreamPool(upstream).SetHealthy(upstream, false)
+	resp, err = http.Get(front.URL + "/api/users")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestUpstreamPool(t *testing.T) {
+	a, _ := ParseUpstream("http://a", 5)
+	b, _ := ParseUpstream("http://b", 1)
+	c, _ := ParseUpstream("http://c", 1)
+	_, err := ParseUpstream("/relative", 1)
+	assert.NotNil(t, err)
+	pool := NewUpstreamPool(a, b, c)
+	var picks string
+	for i := 0; i < 7; i++ {
+		picks += pool.Next().URL.Host
+	}
+	// smooth weighted round robin interleaves
+	assert.Equal(t, "aabacaa", picks)
+	pool.SetHealthy(a, false)
+	pool.SetHealthy(c, false)
+	assert.Equal(t, b, pool.Next())
+	pool.SetHealthy(b, false)
+	assert.Nil(t, pool.Next())
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	var unhealthy int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/base/healthz", req.URL.Path)
+		if atomic.LoadInt32(&unhealthy) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	up, _ := ParseUpstream(backend.URL+"/base", 1)
+	down, _ := ParseUpstream("http://127.0.0.1:1", 1)
+	pool := NewUpstreamPool(up, down)
+	var changes []bool
+	opts := HealthCheckOptions{Path: "/healthz", UnhealthyThreshold: 2, OnChange: func(u *Upstream, healthy bool) {
+		if u == up {
+			changes = append(changes, healthy)
+		}
+	}}
+	pool.CheckHealth(context.Background(), opts)
+	assert.True(t, up.Healthy())
+	assert.True(t, down.Healthy())
+	pool.CheckHealth(context.Background(), opts)
+	assert.False(t, down.Healthy())
+
+	atomic.StoreInt32(&unhealthy, 1)
+	pool.CheckHealth(context.Background(), opts)
+	assert.True(t, up.Healthy())
+	pool.CheckHealth(context.Background(), opts)
+	assert.False(t, up.Healthy())
+	atomic.StoreInt32(&unhealthy, 0)
+	pool.CheckHealth(context.Background(), opts)
+	assert.True(t, up.Healthy())
+	assert.Equal(t, []bool{false, true}, changes)
+}
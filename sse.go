@@ -0,0 +1,540 @@
+package utility
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+	HTTPHeaderLastEventID  = "Last-Event-ID"
+	HTTPHeaderCacheControl = "Cache-Control"
+
+	defaultSSERetry        = 3 * time.Second
+	defaultSSEPollInterval = 100 * time.Millisecond
+	maxSSELineSize         = 1 << 20
+)
+
+var ErrStreamingUnsupported = NewError("response writer does not support flushing").
+	WithCode("streaming_unsupported").WithCategory(ErrorCategoryInternal)
+
+// SSEEvent is a Server-Sent Event, Event "" is a "message" event.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry asks clients to wait this long before reconnecting, 0 leaves it unchanged.
+	Retry time.Duration
+}
+
+// SSEWriter writes Server-Sent Events to a response, flushing each one. It is safe for concurrent use.
+type SSEWriter struct {
+	lock    sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	err     error
+}
+
+// NewSSEWriter starts an event stream response, ErrStreamingUnsupported is returned if w can't be flushed.
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	header := w.Header()
+	header.Set(HTTPHeaderContentType, ContentTypeEventStream)
+	header.Set(HTTPHeaderCacheControl, "no-cache")
+	// disable buffering of nginx
+	header.Set("X-Accel-Buffering", "no")
+	header.Del(HTTPHeaderContentLength)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEWriter{w: w, flusher: flusher}, nil
+}
+
+// Send writes ev, multi-line data is sent as several data lines. IDs and event names can't hold line breaks.
+func (s *SSEWriter) Send(ev *SSEEvent) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return fmt.Errorf("invalid event id %q or name %q", ev.ID, ev.Event)
+	}
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		buf.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	if ev.Data != "" || (ev.ID == "" && ev.Event == "" && ev.Retry == 0) {
+		data := strings.ReplaceAll(strings.ReplaceAll(ev.Data, "\r\n", "\n"), "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+func (s *SSEWriter) SendData(data string) error {
+	return s.Send(&SSEEvent{Data: data})
+}
+
+// SetRetry sets the reconnection delay of the client.
+func (s *SSEWriter) SetRetry(d time.Duration) error {
+	return s.write([]byte("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n"))
+}
+
+// Comment writes a comment line, ignored by clients but keeping the connection alive.
+func (s *SSEWriter) Comment(text string) error {
+	return s.write([]byte(": " + strings.NewReplacer("\r", " ", "\n", " ").Replace(text) + "\n\n"))
+}
+
+// StartHeartbeat writes a comment every interval until stop is called or a write fails,
+// so that proxies don't close an idle stream.
+func (s *SSEWriter) StartHeartbeat(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.Comment("ping") != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// Err returns the first write error, after which nothing is written anymore.
+func (s *SSEWriter) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+func (s *SSEWriter) write(p []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.Write(p); err != nil {
+		s.err = err
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// SSEDecoder parses an event stream.
+type SSEDecoder struct {
+	scanner     *bufio.Scanner
+	lastEventID string
+	retry       time.Duration
+	started     bool
+}
+
+func NewSSEDecoder(r io.Reader) *SSEDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxSSELineSize)
+	scanner.Split(scanSSELines)
+	return &SSEDecoder{scanner: scanner}
+}
+
+// LastEventID returns the ID of the last event, which carries over to events without one.
+func (d *SSEDecoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Retry returns the last reconnection delay sent by the server, 0 if none.
+func (d *SSEDecoder) Retry() time.Duration {
+	return d.retry
+}
+
+// Next returns the next event, io.EOF at the end of the stream. An event not terminated
+// by a blank line at the end of the stream is dropped.
+func (d *SSEDecoder) Next() (*SSEEvent, error) {
+	var data strings.Builder
+	event, hasData := "", false
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if !d.started {
+			d.started = true
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			if !hasData {
+				event = ""
+				continue
+			}
+			return &SSEEvent{ID: d.lastEventID, Event: event, Data: strings.TrimSuffix(data.String(), "\n")}, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				d.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// scanSSELines splits lines ended by "\r\n", "\r" or "\n".
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// a "\n" may follow
+		return 0, nil, nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+type SSEClientOptions struct {
+	// Client defaults to http.DefaultClient, it should have no timeout as streams are long-lived.
+	Client *http.Client
+	Header http.Header
+	// LastEventID resumes a stream.
+	LastEventID string
+	// Retry is the reconnection delay until the server sends one, default 3s.
+	Retry time.Duration
+	// MaxRetries bounds consecutive failed connections, 0 means unlimited.
+	MaxRetries int
+}
+
+// SubscribeSSE reads the event stream at url and calls fn with each event, reconnecting with
+// Last-Event-ID when the stream ends or fails. It returns when ctx is done, fn returns an error,
+// the server answers 204 No Content, or a response is not an event stream.
+func SubscribeSSE(ctx context.Context, url string, opts SSEClientOptions, fn func(ev *SSEEvent) error) error {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = defaultSSERetry
+	}
+	lastEventID, retry, failures := opts.LastEventID, opts.Retry, 0
+	for {
+		received, err := readSSEStream(ctx, url, &opts, lastEventID, func(decoder *SSEDecoder, ev *SSEEvent) error {
+			lastEventID = decoder.LastEventID()
+			return fn(ev)
+		}, &retry)
+		if errors.Is(err, errSSEStreamDone) {
+			return nil
+		}
+		var fatal *sseFatalError
+		if errors.As(err, &fatal) {
+			return fatal.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			failures = 0
+		} else if failures++; opts.MaxRetries > 0 && failures > opts.MaxRetries {
+			return WrapError(err, "event stream failed", "url", url, "retries", opts.MaxRetries)
+		}
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+var errSSEStreamDone = errors.New("event stream done")
+
+// sseFatalError ends SubscribeSSE without reconnecting.
+type sseFatalError struct {
+	err error
+}
+
+func (e *sseFatalError) Error() string {
+	return e.err.Error()
+}
+
+// readSSEStream reads one connection, received reports whether it got a valid stream.
+func readSSEStream(ctx context.Context, url string, opts *SSEClientOptions, lastEventID string,
+	fn func(decoder *SSEDecoder, ev *SSEEvent) error, retry *time.Duration) (received bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, &sseFatalError{err: err}
+	}
+	for key, values := range opts.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", ContentTypeEventStream)
+	req.Header.Set(HTTPHeaderCacheControl, "no-cache")
+	if lastEventID != "" {
+		req.Header.Set(HTTPHeaderLastEventID, lastEventID)
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return true, errSSEStreamDone
+	case resp.StatusCode >= 500:
+		return false, fmt.Errorf("event stream status %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return false, &sseFatalError{err: fmt.Errorf("event stream status %s", resp.Status)}
+	case !strings.HasPrefix(resp.Header.Get(HTTPHeaderContentType), ContentTypeEventStream):
+		return false, &sseFatalError{err: fmt.Errorf("unexpected event stream content type %q", resp.Header.Get(HTTPHeaderContentType))}
+	}
+	decoder := NewSSEDecoder(resp.Body)
+	decoder.lastEventID = lastEventID
+	for {
+		ev, err := decoder.Next()
+		if decoder.Retry() > 0 {
+			*retry = decoder.Retry()
+		}
+		if err != nil {
+			return true, err
+		}
+		if err = fn(decoder, ev); err != nil {
+			return true, &sseFatalError{err: err}
+		}
+	}
+}
+
+type SSEBroadcasterOptions struct {
+	// Encode turns a queue item into an event, its ID is set by the broadcaster. Default formats the item with %v.
+	Encode func(item interface{}) (*SSEEvent, error)
+	// PollInterval of the queue, default 100ms.
+	PollInterval time.Duration
+	// History is the number of events kept to resend to clients reconnecting with Last-Event-ID.
+	History int
+	// ClientBuffer is the number of events queued per client, a client falling behind is disconnected. Default 64.
+	ClientBuffer int
+	// Heartbeat is the interval of keep-alive comments, 0 disables them.
+	Heartbeat time.Duration
+	// Retry is sent to clients on connection if > 0.
+	Retry time.Duration
+}
+
+// SSEBroadcaster shifts items from a SyncQueue and streams them as events to all connected clients.
+// Events are numbered from 1, their IDs let reconnecting clients receive the events they missed
+// as long as those are in the history.
+type SSEBroadcaster struct {
+	queue   *SyncQueue
+	opts    SSEBroadcasterOptions
+	lock    sync.Mutex
+	clients map[chan *SSEEvent]struct{}
+	history []*SSEEvent
+	nextID  uint64
+}
+
+func NewSSEBroadcaster(queue *SyncQueue, opts SSEBroadcasterOptions) *SSEBroadcaster {
+	if opts.Encode == nil {
+		opts.Encode = func(item interface{}) (*SSEEvent, error) {
+			return &SSEEvent{Data: fmt.Sprint(item)}, nil
+		}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultSSEPollInterval
+	}
+	if opts.ClientBuffer <= 0 {
+		opts.ClientBuffer = 64
+	}
+	return &SSEBroadcaster{queue: queue, opts: opts, clients: make(map[chan *SSEEvent]struct{}), nextID: 1}
+}
+
+// Start polls the queue until stop is called, then disconnects the clients.
+func (b *SSEBroadcaster) Start() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(b.opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			b.Drain()
+			select {
+			case <-ticker.C:
+			case <-done:
+				b.closeClients()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// Drain shifts all items of the queue and broadcasts them, items failing to encode are dropped.
+func (b *SSEBroadcaster) Drain() {
+	for {
+		items := b.queue.ShiftWithCount(b.opts.ClientBuffer)
+		if len(items) == 0 {
+			return
+		}
+		for _, item := range items {
+			ev, err := b.opts.Encode(item)
+			if err != nil || ev == nil {
+				continue
+			}
+			b.broadcast(ev)
+		}
+	}
+}
+
+func (b *SSEBroadcaster) broadcast(ev *SSEEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ev.ID = strconv.FormatUint(b.nextID, 10)
+	b.nextID++
+	if b.opts.History > 0 {
+		if len(b.history) == b.opts.History {
+			b.history = append(b.history[:0], b.history[1:]...)
+		}
+		b.history = append(b.history, ev)
+	}
+	for client := range b.clients {
+		select {
+		case client <- ev:
+		default:
+			// too slow, the client reconnects and catches up from the history
+			delete(b.clients, client)
+			close(client)
+		}
+	}
+}
+
+// Clients returns the number of connected clients.
+func (b *SSEBroadcaster) Clients() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.clients)
+}
+
+func (b *SSEBroadcaster) closeClients() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for client := range b.clients {
+		delete(b.clients, client)
+		close(client)
+	}
+}
+
+// subscribe registers a client and returns the events of the history after lastEventID.
+func (b *SSEBroadcaster) subscribe(lastEventID string) (chan *SSEEvent, []*SSEEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	client := make(chan *SSEEvent, b.opts.ClientBuffer)
+	b.clients[client] = struct{}{}
+	var missed []*SSEEvent
+	if last, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		for _, ev := range b.history {
+			if id, _ := strconv.ParseUint(ev.ID, 10, 64); id > last {
+				missed = append(missed, ev)
+			}
+		}
+	}
+	return client, missed
+}
+
+func (b *SSEBroadcaster) unsubscribe(client chan *SSEEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.clients[client]; ok {
+		delete(b.clients, client)
+		close(client)
+	}
+}
+
+// ServeHTTP streams events to a client until it disconnects or the broadcaster stops.
+func (b *SSEBroadcaster) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	writer, err := NewSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, missed := b.subscribe(req.Header.Get(HTTPHeaderLastEventID))
+	defer b.unsubscribe(client)
+	if b.opts.Retry > 0 {
+		if writer.SetRetry(b.opts.Retry) != nil {
+			return
+		}
+	}
+	if b.opts.Heartbeat > 0 {
+		defer writer.StartHeartbeat(b.opts.Heartbeat)()
+	}
+	for _, ev := range missed {
+		if writer.Send(ev) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case ev, ok := <-client:
+			if !ok || writer.Send(ev) != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
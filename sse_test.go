@@ -0,0 +1,155 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSEWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	writer, err := NewSSEWriter(w)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Send(&SSEEvent{ID: "1", Event: "update", Data: "a\nb", Retry: 2 * time.Second}))
+	assert.Nil(t, writer.SendData("c"))
+	assert.Nil(t, writer.Comment("ping"))
+	assert.NotNil(t, writer.Send(&SSEEvent{ID: "1\n2"}))
+	assert.Equal(t, ContentTypeEventStream, w.Header().Get(HTTPHeaderContentType))
+	assert.Equal(t, "id: 1\nevent: update\nretry: 2000\ndata: a\ndata: b\n\ndata: c\n\n: ping\n\n", w.Body.String())
+	assert.True(t, w.Flushed)
+}
+
+func TestSSEDecoder(t *testing.T) {
+	stream := "\ufeff: comment\r\nid: 1\r\nevent: update\r\ndata: a\r\ndata:b\r\n\r\n" +
+		"retry: 1500\rdata\r\rid\n\nid: 3\nevent: dropped\n\ndata: unterminated"
+	decoder := NewSSEDecoder(strings.NewReader(stream))
+	ev, err := decoder.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, &SSEEvent{ID: "1", Event: "update", Data: "a\nb"}, ev)
+	ev, err = decoder.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, &SSEEvent{ID: "1", Data: ""}, ev)
+	assert.Equal(t, 1500*time.Millisecond, decoder.Retry())
+	_, err = decoder.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "3", decoder.LastEventID())
+}
+
+func TestSSEBroadcaster(t *testing.T) {
+	queue := NewSyncQueue()
+	broadcaster := NewSSEBroadcaster(queue, SSEBroadcasterOptions{
+		PollInterval: 5 * time.Millisecond,
+		History:      10,
+		Retry:        10 * time.Millisecond,
+		Encode: func(item interface{}) (*SSEEvent, error) {
+			return &SSEEvent{Event: "tick", Data: item.(string)}, nil
+		},
+	})
+	stop := broadcaster.Start()
+	defer stop()
+
+	// the server drops connections after every 2 events to exercise reconnection
+	var connections int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&connections, 1)
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		counting := &countingFlushWriter{ResponseWriter: w, limit: 2, cancel: cancel}
+		broadcaster.ServeHTTP(counting, req.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var received []string
+	var ids []string
+	errStop := errors.New("stop")
+	go func() {
+		for broadcaster.Clients() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		queue.Push("a", "b", "c", "d", "e")
+	}()
+	err := SubscribeSSE(ctx, server.URL, SSEClientOptions{}, func(ev *SSEEvent) error {
+		received = append(received, ev.Data)
+		ids = append(ids, ev.ID)
+		if len(received) == 5 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, received)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, ids)
+	assert.True(t, atomic.LoadInt32(&connections) >= 3)
+}
+
+// countingFlushWriter cancels the request after limit events.
+type countingFlushWriter struct {
+	http.ResponseWriter
+	limit  int
+	events int
+	cancel context.CancelFunc
+}
+
+func (w *countingFlushWriter) Write(p []byte) (int, error) {
+	if w.events >= w.limit {
+		return 0, io.ErrClosedPipe
+	}
+	if strings.Contains(string(p), "data:") {
+		if w.events++; w.events == w.limit {
+			defer w.cancel()
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *countingFlushWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func TestSubscribeSSEFailures(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set(HTTPHeaderContentType, ContentTypeEventStream)
+			_, _ = w.Write([]byte("retry: 1\nid: 7\ndata: x\n\n"))
+		default:
+			assert.Equal(t, "7", req.Header.Get(HTTPHeaderLastEventID))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	var events []string
+	err := SubscribeSSE(context.Background(), server.URL, SSEClientOptions{Retry: time.Millisecond}, func(ev *SSEEvent) error {
+		events = append(events, ev.Data)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x"}, events)
+
+	notStream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer notStream.Close()
+	err = SubscribeSSE(context.Background(), notStream.URL, SSEClientOptions{}, func(ev *SSEEvent) error { return nil })
+	assert.NotNil(t, err)
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	err = SubscribeSSE(context.Background(), down.URL, SSEClientOptions{Retry: time.Millisecond, MaxRetries: 2}, func(ev *SSEEvent) error { return nil })
+	assert.NotNil(t, err)
+}
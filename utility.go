@@ -16,12 +16,20 @@ import (
 	"unsafe"
 )
 
+// PanicLogsStack opts PanicIfNotNil into its legacy behavior of logging err and
+// dumping the stack to stderr before panicking.
+var PanicLogsStack = false
+
+// PanicIfNotNil panics with err itself, so callers recovering it can still compare or
+// type-assert it. Use Recover to turn the panic into an *Error with the stack of the panic site.
 func PanicIfNotNil(err error) {
 	if err == nil {
 		return
 	}
-	log.Println(err)
-	debug.PrintStack()
+	if PanicLogsStack {
+		log.Println(err)
+		debug.PrintStack()
+	}
 	panic(err)
 }
 
@@ -3,6 +3,7 @@ package utility
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"fmt"
 	"io"
 	"os"
@@ -177,7 +178,7 @@ func NewZipArchiveWriterToFile(path string) (ZipArchiveWriter, error) {
 	if err != nil {
 		return nil, err
 	}
-	inst := &zipArchiveWriter{writer: zip.NewWriter(zipFP)}
+	inst := &zipArchiveWriter{writer: newZipWriter(zipFP)}
 	runtime.SetFinalizer(inst, func(z *zipArchiveWriter) {
 		_ = zipFP.Close()
 	})
@@ -185,7 +186,20 @@ func NewZipArchiveWriterToFile(path string) (ZipArchiveWriter, error) {
 }
 
 func NewZipArchiveWriter(w io.Writer) ZipArchiveWriter {
-	return &zipArchiveWriter{writer: zip.NewWriter(w)}
+	return &zipArchiveWriter{writer: newZipWriter(w)}
+}
+
+// newZipWriter returns a zip.Writer compressing with the registered "deflate" codec.
+func newZipWriter(w io.Writer) *zip.Writer {
+	writer := zip.NewWriter(w)
+	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		codec := CodecByName(CodecDeflate)
+		if codec == nil || !codec.CanEncode() {
+			return flate.NewWriter(out, flate.DefaultCompression)
+		}
+		return codec.NewWriter(out, CodecDefaultLevel)
+	})
+	return writer
 }
 
 type zipArchiveWriter struct {